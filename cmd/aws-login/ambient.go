@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ambientProfile names the profile offered when no profiles are defined but
+// the environment provides credentials, e.g. an EC2 instance role. It can't
+// clash with a profile from the credentials file because '@' isn't allowed
+// in profile names.
+const ambientProfile = "@environment"
+
+// imdsTokenURL is the instance metadata service's IMDSv2 token endpoint.
+const imdsTokenURL = "http://169.254.169.254/latest/api/token"
+
+const imdsTimeout = 300 * time.Millisecond
+
+// ambientCredentialSource returns a description of where the AWS CLI would
+// find credentials without a profile, or "" if it looks like it wouldn't.
+func ambientCredentialSource(getenv func(string) string, imdsReachable func() bool) string {
+	switch {
+	case getenv("AWS_ACCESS_KEY_ID") != "" && getenv("AWS_SECRET_ACCESS_KEY") != "":
+		return "environment variables"
+	case getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		return "container credentials"
+	case getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "":
+		return "web identity token"
+	case getenv("AWS_EC2_METADATA_DISABLED") != "true" && imdsReachable():
+		return "instance metadata"
+	}
+	return ""
+}
+
+// imdsReachable reports whether the EC2 instance metadata service answers a
+// token request.
+func imdsReachable() bool {
+	req, err := http.NewRequest(http.MethodPut, imdsTokenURL, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "1")
+	resp, err := (&http.Client{Timeout: imdsTimeout}).Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// ambientProfiles returns the synthetic ambientProfile if the environment
+// has credentials, and no profiles otherwise.
+func ambientProfiles() map[string]AWSProfile {
+	source := ambientCredentialSource(os.Getenv, imdsReachable)
+	if source == "" {
+		return nil
+	}
+	return map[string]AWSProfile{
+		ambientProfile: {Name: ambientProfile, Description: "credentials from " + source},
+	}
+}
+
+// awsProfileEnv returns environ with AWS_PROFILE set to profileName, or
+// removed for ambientProfile so the AWS CLI falls back to the ambient
+// credentials.
+func awsProfileEnv(environ []string, profileName string) []string {
+	if profileName != ambientProfile {
+		return setEnv(environ, map[string]string{"AWS_PROFILE": profileName})
+	}
+	var result []string
+	for _, entry := range environ {
+		if name, _, _ := strings.Cut(entry, "="); name != "AWS_PROFILE" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAmbientCredentialSource(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		imds bool
+		want string
+	}{
+		{"keys", map[string]string{"AWS_ACCESS_KEY_ID": "AKIA1", "AWS_SECRET_ACCESS_KEY": "s"}, true, "environment variables"},
+		{"key without secret", map[string]string{"AWS_ACCESS_KEY_ID": "AKIA1"}, false, ""},
+		{"ecs", map[string]string{"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "/v2/credentials/x"}, false, "container credentials"},
+		{"eks", map[string]string{"AWS_WEB_IDENTITY_TOKEN_FILE": "/var/run/token"}, false, "web identity token"},
+		{"ec2", nil, true, "instance metadata"},
+		{"ec2 metadata disabled", map[string]string{"AWS_EC2_METADATA_DISABLED": "true"}, true, ""},
+		{"nothing", nil, false, ""},
+	}
+	for _, tt := range tests {
+		getenv := func(name string) string { return tt.env[name] }
+		if got := ambientCredentialSource(getenv, func() bool { return tt.imds }); got != tt.want {
+			t.Errorf("%s: ambientCredentialSource = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAmbientProfile(t *testing.T) {
+	home := t.TempDir()
+	env := []string{"AWS_ACCESS_KEY_ID=AKIA1", "AWS_SECRET_ACCESS_KEY=s", "AWS_EC2_METADATA_DISABLED=true"}
+
+	if result := runMain(t, home, "", env, "-list"); result.code != 0 || result.stdout != ambientProfile+"\n" {
+		t.Errorf("without a credentials file: exit code %d, output %q, want only %s", result.code, result.stdout, ambientProfile)
+	}
+
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	if result := runMain(t, home, "", env, "-list"); result.stdout != "dev\n" {
+		t.Errorf("with profiles: output %q, want only dev", result.stdout)
+	}
+
+	if result := runMain(t, t.TempDir(), "", []string{"AWS_EC2_METADATA_DISABLED=true"}, "-list"); result.code != exitFileNotFound {
+		t.Errorf("without credentials anywhere: exit code %d, want %d", result.code, exitFileNotFound)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"time"
+)
+
+// auditRecord is a line of the -audit-log file. It never holds credentials.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"`
+	Profile  string    `json:"profile"`
+	Account  string    `json:"account,omitempty"`
+	Arn      string    `json:"arn,omitempty"`
+	Region   string    `json:"region,omitempty"`
+	Verified bool      `json:"verified"`
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// newAuditRecord describes using profile in region at now. output and
+// verifyErr are the result of verifying it, if it was verified.
+func newAuditRecord(profile AWSProfile, region string, verified bool, output []byte, verifyErr error, now time.Time) auditRecord {
+	record := auditRecord{
+		Time:     now.UTC(),
+		Profile:  profile.Name,
+		Account:  profile.AWSAccountID,
+		Region:   region,
+		Verified: verified,
+		Success:  verifyErr == nil,
+	}
+	if current, err := user.Current(); err == nil {
+		record.User = current.Username
+	}
+	if verifyErr != nil {
+		record.Error = verifyErr.Error()
+	} else if identity, err := parseCallerIdentity(output); verified && err == nil {
+		record.Account = identity.Account
+		record.Arn = identity.Arn
+	}
+	return record
+}
+
+// appendAuditRecord appends record to the JSON lines file at path, holding
+// a lock next to it so records from concurrent runs don't interleave.
+func appendAuditRecord(path string, record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	unlock, err := acquireLock(path+".lock", lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const awsCLIInstallGuide = "https://docs.aws.amazon.com/cli/latest/userguide/getting-started-install.html"
+
+// awsCLIVersionRegexp matches the version in `aws --version` output, e.g.
+// "aws-cli/2.15.0 Python/3.11.6 Linux/6.5.0 exe/x86_64".
+var awsCLIVersionRegexp = regexp.MustCompile(`aws-cli/([0-9]+(?:\.[0-9]+)*)`)
+
+func awsVersionCommand() *exec.Cmd {
+	return exec.Command(cfg.AWSCLIPath, "--version")
+}
+
+// checkAWSCLI checks that the AWS CLI can be found and, if minVersion is
+// set, that it is at least that version. The errors say how to fix the
+// problem.
+func checkAWSCLI(minVersion string) error {
+	if _, err := exec.LookPath(cfg.AWSCLIPath); err != nil {
+		return fmt.Errorf("AWS CLI not found at %q: install it (%s) or set AWS_CLI_PATH", cfg.AWSCLIPath, awsCLIInstallGuide)
+	}
+	if minVersion == "" {
+		return nil
+	}
+	// Version 1 of the CLI prints its version to stderr.
+	output, err := awsVersionCommand().CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running %s --version: %v", cfg.AWSCLIPath, err)
+	}
+	version, ok := parseAWSCLIVersion(string(output))
+	if !ok {
+		return fmt.Errorf("unrecognized output from %s --version: %q", cfg.AWSCLIPath, strings.TrimSpace(string(output)))
+	}
+	if compareVersions(version, minVersion) < 0 {
+		return fmt.Errorf("AWS CLI %s is older than the required %s: upgrade it (%s)", version, minVersion, awsCLIInstallGuide)
+	}
+	return nil
+}
+
+// parseAWSCLIVersion returns the version number in `aws --version` output.
+func parseAWSCLIVersion(output string) (string, bool) {
+	match := awsCLIVersionRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// compareVersions compares dotted version numbers, returning -1, 0 or 1.
+// Missing components count as zero, so "2" equals "2.0.0".
+func compareVersions(a, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(partsA), len(partsB)); i++ {
+		var x, y int
+		if i < len(partsA) {
+			x, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			y, _ = strconv.Atoi(partsB[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
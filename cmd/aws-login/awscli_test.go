@@ -0,0 +1,97 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configureScript is a fake AWS CLI that lists the profiles dev and admin
+// and answers `aws configure get` for their settings.
+const configureScript = `case "$1 $2" in
+"configure list-profiles") printf 'dev\nadmin\n' ;;
+"configure get")
+	case "$5:$3" in
+	dev:aws_access_key_id) echo AKIADEV ;;
+	dev:aws_secret_access_key) echo wJalrXUtnFEMI ;;
+	dev:region) echo us-east-1 ;;
+	admin:role_arn) echo arn:aws:iam::111111111111:role/admin ;;
+	admin:source_profile) echo dev ;;
+	*) exit 1 ;;
+	esac ;;
+*) exit 2 ;;
+esac
+`
+
+func TestLoadProfilesFromAWSCLI(t *testing.T) {
+	c := defaultConfig()
+	c.AWSCLIPath = fakeCLI(t, configureScript)
+	setConfig(t, c)
+
+	profiles, err := loadProfilesFromAWSCLI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]AWSProfile{
+		"dev":   {Name: "dev", AWSAccessKeyID: "AKIADEV", AWSSecretAccessKey: redactedValue, Region: "us-east-1"},
+		"admin": {Name: "admin", RoleARN: "arn:aws:iam::111111111111:role/admin", SourceProfile: "dev"},
+	}
+	if !maps.Equal(profiles, want) {
+		t.Errorf("got %+v, want %+v", profiles, want)
+	}
+}
+
+func TestCheckAWSCLI(t *testing.T) {
+	v2 := fakeCLI(t, "echo 'aws-cli/2.15.30 Python/3.11.8 Darwin/23.4.0 exe/x86_64 prompt/off'\n")
+	// Version 1 prints its version to stderr.
+	v1 := fakeCLI(t, "echo 'aws-cli/1.29.0 Python/3.9.6 Linux/5.15.0 botocore/1.31.0' >&2\n")
+	tests := []struct {
+		name       string
+		path       string
+		minVersion string
+		wantErr    string
+	}{
+		{"missing", filepath.Join(t.TempDir(), "aws"), "", "AWS CLI not found at"},
+		{"no minimum", v1, "", ""},
+		{"acceptable", v2, "2.15", ""},
+		{"equal", v2, "2.15.30", ""},
+		{"too old", v1, "2", "AWS CLI 1.29.0 is older than the required 2: upgrade it"},
+		{"older patch", v2, "2.15.31", "AWS CLI 2.15.30 is older than the required 2.15.31"},
+		{"unrecognized", fakeCLI(t, "echo hello\n"), "2", "unrecognized output from"},
+		{"failing", fakeCLI(t, "exit 1\n"), "2", "error running"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.AWSCLIPath = tt.path
+			setConfig(t, c)
+			err := checkAWSCLI(tt.minVersion)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkAWSCLI(%q) = %v", tt.minVersion, err)
+				}
+			} else if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("checkAWSCLI(%q) = %v, want an error starting with %q", tt.minVersion, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"2.15.30", "2.15.30", 0},
+		{"2", "2.0.0", 0},
+		{"2.9.0", "2.10.0", -1},
+		{"2.10", "2.9.9", 1},
+		{"1.29.0", "2", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
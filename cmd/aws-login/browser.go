@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// browserCommand returns the command that opens url in the default browser
+// on goos, or false if there is no known way to do so.
+func browserCommand(goos, url string) (string, []string, bool) {
+	switch goos {
+	case "darwin":
+		return "open", []string{url}, true
+	case "windows":
+		return "rundll32", []string{"url.dll,FileProtocolHandler", url}, true
+	case "linux", "freebsd", "netbsd", "openbsd":
+		return "xdg-open", []string{url}, true
+	}
+	return "", nil, false
+}
+
+// openURL opens url in the browser, printing it instead when no browser can
+// be launched.
+func openURL(url string) {
+	name, args, ok := browserCommand(runtime.GOOS, url)
+	if ok {
+		if err := exec.Command(name, args...).Start(); err == nil {
+			logInfo("Opened %s\n", url)
+			return
+		}
+	}
+	fmt.Printf("Open %s in your browser\n", url)
+}
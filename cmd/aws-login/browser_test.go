@@ -0,0 +1,28 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBrowserCommand(t *testing.T) {
+	const url = "https://console.aws.amazon.com/?region=us-east-1"
+	tests := []struct {
+		goos string
+		name string
+		args []string
+		ok   bool
+	}{
+		{"darwin", "open", []string{url}, true},
+		{"windows", "rundll32", []string{"url.dll,FileProtocolHandler", url}, true},
+		{"linux", "xdg-open", []string{url}, true},
+		{"openbsd", "xdg-open", []string{url}, true},
+		{"plan9", "", nil, false},
+	}
+	for _, tt := range tests {
+		name, args, ok := browserCommand(tt.goos, url)
+		if name != tt.name || !slices.Equal(args, tt.args) || ok != tt.ok {
+			t.Errorf("browserCommand(%s) = %q, %q, %v, want %q, %q, %v", tt.goos, name, args, ok, tt.name, tt.args, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/aymanbagabas/go-osc52/v2"
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+)
+
+// copyAccountKey copies the account id of the highlighted profile in the
+// profile prompts.
+var copyAccountKey = key.NewBinding(key.WithKeys("ctrl+y"), key.WithHelp("ctrl+y", "copy account id"))
+
+// copyFlashDuration is how long the prompts show that an account id was
+// copied.
+const copyFlashDuration = 2 * time.Second
+
+// copyToClipboard copies text with the system clipboard, falling back to
+// the OSC 52 escape sequence, which many terminals (including over SSH)
+// turn into a clipboard write.
+func copyToClipboard(text string) error {
+	if err := clipboard.WriteAll(text); err == nil {
+		return nil
+	}
+	_, err := osc52.New(text).WriteTo(infoOutput)
+	return err
+}
+
+// promptState is what the profile prompts' descriptions depend on. Its
+// fields are exported so huh notices when they change.
+type promptState struct {
+	// Selected is the highlighted profile.
+	Selected string
+	// Flash is a short-lived message shown under the profile details.
+	Flash string
+}
+
+// copyAccountID copies the account id of profile and returns the message
+// to flash.
+func copyAccountID(profile AWSProfile, copy func(string) error) string {
+	if profile.AWSAccountID == "" {
+		return "No account id to copy"
+	}
+	if err := copy(profile.AWSAccountID); err != nil {
+		return fmt.Sprintf("Could not copy the account id: %v", err)
+	}
+	return "Copied " + profile.AWSAccountID + " to the clipboard"
+}
+
+// copiedMsg stands in for the copy key press, which the form mustn't see,
+// so that the form still rebuilds the description.
+type copiedMsg struct{}
+
+type clearFlashMsg struct{}
+
+// profilePromptModel runs a profile prompt form, copying the highlighted
+// profile's account id on copyAccountKey without selecting it.
+type profilePromptModel struct {
+	form     *huh.Form
+	profiles map[string]AWSProfile
+	state    *promptState
+	// topMatch, if set, returns the best match for a search prompt, which
+	// enter in its search input selects. huh would only move to the list.
+	topMatch func() string
+	// inList is set while the search prompt's list, which follows the
+	// input, has the focus.
+	inList bool
+}
+
+func (m profilePromptModel) Init() tea.Cmd {
+	return m.form.Init()
+}
+
+func (m profilePromptModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var flashCmd tea.Cmd
+	switch typed := msg.(type) {
+	case tea.KeyMsg:
+		if m.topMatch != nil {
+			switch typed.String() {
+			case "enter":
+				if !m.inList {
+					if name := m.topMatch(); name != "" {
+						m.state.Selected = name
+						m.form.State = huh.StateCompleted
+						return m, tea.Quit
+					}
+					return m, nil
+				}
+			case "tab":
+				m.inList = true
+			case "shift+tab":
+				m.inList = false
+			}
+		}
+		if key.Matches(typed, copyAccountKey) {
+			m.state.Flash = copyAccountID(m.profiles[m.state.Selected], copyToClipboard)
+			flashCmd = tea.Tick(copyFlashDuration, func(time.Time) tea.Msg { return clearFlashMsg{} })
+			msg = copiedMsg{}
+		}
+	case clearFlashMsg:
+		m.state.Flash = ""
+	}
+
+	form, cmd := m.form.Update(msg)
+	m.form = form.(*huh.Form)
+	if m.form.State != huh.StateNormal {
+		return m, tea.Quit
+	}
+	return m, tea.Batch(cmd, flashCmd)
+}
+
+func (m profilePromptModel) View() string {
+	if m.form.State != huh.StateNormal {
+		return ""
+	}
+	return m.form.View()
+}
+
+// runProfilePrompt runs form, a profile prompt whose description is bound to
+// state, like huh's Form.Run but with copyAccountKey. For a search prompt,
+// topMatch returns the profile enter selects from the search input.
+func runProfilePrompt(form *huh.Form, profiles map[string]AWSProfile, state *promptState, topMatch func() string) error {
+	if cfg.NoPrompt {
+		return errNoPrompt
+	}
+	model := profilePromptModel{form: form, profiles: profiles, state: state, topMatch: topMatch}
+	result, err := tea.NewProgram(model, tea.WithOutput(infoOutput), tea.WithReportFocus()).Run()
+	if err != nil {
+		return fmt.Errorf("huh: %w", err)
+	}
+	if result.(profilePromptModel).form.State == huh.StateAborted {
+		return huh.ErrUserAborted
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCopyAccountID(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"dev":     {Name: "dev", AWSAccountID: "111111111111"},
+		"prod":    {Name: "prod", AWSAccountID: "222222222222"},
+		"sandbox": {Name: "sandbox"},
+	}
+	tests := []struct {
+		selected string
+		copyErr  error
+		copied   string
+		flash    string
+	}{
+		{"prod", nil, "222222222222", "Copied 222222222222 to the clipboard"},
+		{"dev", nil, "111111111111", "Copied 111111111111 to the clipboard"},
+		{"sandbox", nil, "", "No account id to copy"},
+		{"dev", errors.New("no clipboard"), "111111111111", "Could not copy the account id: no clipboard"},
+	}
+	for _, tt := range tests {
+		var copied string
+		copy := func(text string) error {
+			copied = text
+			return tt.copyErr
+		}
+		if flash := copyAccountID(profiles[tt.selected], copy); flash != tt.flash {
+			t.Errorf("%s: flashed %q, want %q", tt.selected, flash, tt.flash)
+		}
+		if copied != tt.copied {
+			t.Errorf("%s: copied %q, want %q", tt.selected, copied, tt.copied)
+		}
+	}
+}
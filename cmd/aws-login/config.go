@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// config holds the defaults for every run. Values are layered with the
+// precedence flag > environment variable > config file > built-in default:
+// loadConfig applies the file and then the environment on top of
+// defaultConfig, and main uses the result as the default of each flag.
+type config struct {
+	Verify        bool
+	AlwaysVerify  []string
+	Confirm       bool
+	JSON          bool
+	Quiet         bool
+	Strict        bool
+	ReadOnly      bool
+	UseOnePassCLI bool
+	UseAWSCLI     bool
+	AWSCLIPath    string
+	OPCLIPath     string
+	MenuHeight    int
+	ASCII         bool
+	Prefix        string
+	StripPrefix   bool
+	SafeOrder     bool
+	RequireRegion bool
+	VerifyRegions []string
+	AccountNames  bool
+	Allow         []string
+	Deny          []string
+	Weights       rankWeights
+	MatchAllTerms bool
+	// NoPrompt makes any prompt fail with errNoPrompt instead of opening.
+	NoPrompt bool
+	// ConfirmAccountSwitch asks for confirmation, as Confirm does, but only
+	// when the selected profile is in another account than the active one.
+	ConfirmAccountSwitch bool
+	// ExpandEnv expands $VAR and ${VAR} in credentials file values.
+	ExpandEnv bool
+	// AWSCLIMinVersion, if set, is the oldest AWS CLI version accepted.
+	AWSCLIMinVersion string
+	// RankCommand, if set, ranks the profiles suggested by -s instead of
+	// the built-in ranking, see externalRank.
+	RankCommand string
+	// EnvRegions maps an environment class to the region used by profiles
+	// of that class that don't set one.
+	EnvRegions map[string]string
+	Profiles   map[string]profileConfig
+}
+
+// profileConfig holds per-profile annotations from [profiles.<name>]
+// sections of the config file.
+type profileConfig struct {
+	URL string
+	// Tags are the session tags passed when assuming the profile's role.
+	Tags []sessionTag
+	// Icon and Color replace the marker and color the prompt derives from
+	// the profile's environment.
+	Icon  string
+	Color string
+	// Env holds extra environment variables for commands run with the
+	// profile after "--".
+	Env map[string]string
+}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var colorRegexp = regexp.MustCompile(`^(#[0-9a-fA-F]{6}|[0-9]{1,3})$`)
+
+// profileSettings maps the keys of a [profiles.<name>] section to the field
+// they set.
+var profileSettings = map[string]func(p *profileConfig, value string) error{
+	"url": func(p *profileConfig, value string) error {
+		p.URL = value
+		return nil
+	},
+	"tags": func(p *profileConfig, value string) error {
+		var tags []sessionTag
+		for _, item := range parseList(value) {
+			tag, err := parseSessionTag(item)
+			if err != nil {
+				return err
+			}
+			tags = append(tags, tag)
+		}
+		p.Tags = tags
+		return nil
+	},
+	"env": func(p *profileConfig, value string) error {
+		env := make(map[string]string)
+		for _, item := range parseList(value) {
+			name, value, ok := strings.Cut(item, "=")
+			if !ok || !envNameRegexp.MatchString(name) {
+				return fmt.Errorf("environment variable %q is not NAME=value", item)
+			}
+			env[name] = value
+		}
+		p.Env = env
+		return nil
+	},
+	"icon": func(p *profileConfig, value string) error {
+		p.Icon = value
+		return nil
+	},
+	"color": func(p *profileConfig, value string) error {
+		if n, err := strconv.Atoi(value); !colorRegexp.MatchString(value) || (err == nil && n > 255) {
+			return fmt.Errorf("%q is not an ANSI color number (0-255) or #rrggbb", value)
+		}
+		p.Color = value
+		return nil
+	},
+}
+
+var cfg = defaultConfig()
+
+func defaultConfig() config {
+	return config{
+		Verify:     true,
+		AWSCLIPath: "aws",
+		OPCLIPath:  "op",
+		Weights:    defaultRankWeights,
+		// Suggesting a profile that matches only one of several terms is
+		// more often wrong than helpful.
+		MatchAllTerms: true,
+		EnvRegions:    make(map[string]string),
+		Profiles:      make(map[string]profileConfig),
+	}
+}
+
+// configSetting maps a config file key (section.key for keys inside a
+// section) and its environment variable to the field it sets.
+type configSetting struct {
+	key   string
+	env   string
+	value settingValue
+}
+
+// settingValue parses a setting into its field, and formats the field the
+// way it is written in the config file.
+type settingValue struct {
+	set    func(c *config, value string) error
+	format func(c *config) string
+}
+
+var configSettings = []configSetting{
+	{"verify", "AWS_PROFILE_SELECTOR_VERIFY", boolSetting(func(c *config) *bool { return &c.Verify })},
+	{"always_verify", "AWS_PROFILE_SELECTOR_ALWAYS_VERIFY", listSetting(func(c *config) *[]string { return &c.AlwaysVerify })},
+	{"confirm", "AWS_PROFILE_SELECTOR_CONFIRM", boolSetting(func(c *config) *bool { return &c.Confirm })},
+	{"confirm_account_switch", "AWS_PROFILE_SELECTOR_CONFIRM_ACCOUNT_SWITCH", boolSetting(func(c *config) *bool { return &c.ConfirmAccountSwitch })},
+	{"no_prompt", "AWS_PROFILE_SELECTOR_NO_PROMPT", boolSetting(func(c *config) *bool { return &c.NoPrompt })},
+	{"json", "AWS_PROFILE_SELECTOR_JSON", boolSetting(func(c *config) *bool { return &c.JSON })},
+	{"quiet", "AWS_PROFILE_SELECTOR_QUIET", boolSetting(func(c *config) *bool { return &c.Quiet })},
+	{"strict", "AWS_PROFILE_SELECTOR_STRICT", boolSetting(func(c *config) *bool { return &c.Strict })},
+	{"read_only", "AWS_PROFILE_SELECTOR_READONLY", boolSetting(func(c *config) *bool { return &c.ReadOnly })},
+	{"use_onepass_cli", "USE_ONEPASS_CLI", trueOnlySetting(func(c *config) *bool { return &c.UseOnePassCLI })},
+	{"use_aws_cli", "AWS_PROFILE_SELECTOR_USE_AWS_CLI", boolSetting(func(c *config) *bool { return &c.UseAWSCLI })},
+	{"aws_cli_path", "AWS_CLI_PATH", stringSetting(func(c *config) *string { return &c.AWSCLIPath })},
+	{"aws_cli_min_version", "AWS_PROFILE_SELECTOR_AWS_CLI_MIN_VERSION", stringSetting(func(c *config) *string { return &c.AWSCLIMinVersion })},
+	{"op_cli_path", "OP_CLI_PATH", stringSetting(func(c *config) *string { return &c.OPCLIPath })},
+	{"menu_height", "AWS_PROFILE_SELECTOR_MENU_HEIGHT", intSetting(func(c *config) *int { return &c.MenuHeight })},
+	{"ascii", "AWS_PROFILE_SELECTOR_ASCII", boolSetting(func(c *config) *bool { return &c.ASCII })},
+	{"prefix", "AWS_PROFILE_SELECTOR_PREFIX", stringSetting(func(c *config) *string { return &c.Prefix })},
+	{"strip_prefix", "AWS_PROFILE_SELECTOR_STRIP_PREFIX", boolSetting(func(c *config) *bool { return &c.StripPrefix })},
+	{"safe_order", "AWS_PROFILE_SELECTOR_SAFE_ORDER", boolSetting(func(c *config) *bool { return &c.SafeOrder })},
+	{"account_names", "AWS_PROFILE_SELECTOR_ACCOUNT_NAMES", boolSetting(func(c *config) *bool { return &c.AccountNames })},
+	{"allow", "AWS_PROFILE_SELECTOR_ALLOW", listSetting(func(c *config) *[]string { return &c.Allow })},
+	{"deny", "AWS_PROFILE_SELECTOR_DENY", listSetting(func(c *config) *[]string { return &c.Deny })},
+	{"match_all_terms", "AWS_PROFILE_SELECTOR_MATCH_ALL_TERMS", boolSetting(func(c *config) *bool { return &c.MatchAllTerms })},
+	{"expand_env", "AWS_PROFILE_SELECTOR_EXPAND_ENV", boolSetting(func(c *config) *bool { return &c.ExpandEnv })},
+	{"rank_command", "AWS_PROFILE_SELECTOR_RANK_CMD", stringSetting(func(c *config) *string { return &c.RankCommand })},
+	{"require_region", "AWS_PROFILE_SELECTOR_REQUIRE_REGION", boolSetting(func(c *config) *bool { return &c.RequireRegion })},
+	{"verify_regions", "AWS_PROFILE_SELECTOR_VERIFY_REGIONS", listSetting(func(c *config) *[]string { return &c.VerifyRegions })},
+	{"weights.substring", "AWS_PROFILE_SELECTOR_WEIGHT_SUBSTRING", intSetting(func(c *config) *int { return &c.Weights.Substring })},
+	{"weights.prefix", "AWS_PROFILE_SELECTOR_WEIGHT_PREFIX", intSetting(func(c *config) *int { return &c.Weights.Prefix })},
+	{"weights.subsequence", "AWS_PROFILE_SELECTOR_WEIGHT_SUBSEQUENCE", intSetting(func(c *config) *int { return &c.Weights.Subsequence })},
+	{"weights.account_id", "AWS_PROFILE_SELECTOR_WEIGHT_ACCOUNT_ID", intSetting(func(c *config) *int { return &c.Weights.AccountID })},
+	{"weights.region", "AWS_PROFILE_SELECTOR_WEIGHT_REGION", intSetting(func(c *config) *int { return &c.Weights.Region })},
+	{"regions.prod", "AWS_PROFILE_SELECTOR_REGION_PROD", envRegionSetting(envProd)},
+	{"regions.test", "AWS_PROFILE_SELECTOR_REGION_TEST", envRegionSetting(envTest)},
+	{"regions.other", "AWS_PROFILE_SELECTOR_REGION_OTHER", envRegionSetting(envOther)},
+}
+
+func boolSetting(field func(c *config) *bool) settingValue {
+	return settingValue{
+		set: func(c *config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", value)
+			}
+			*field(c) = b
+			return nil
+		},
+		format: func(c *config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+// trueOnlySetting is a boolSetting that is on only for "true" and off for
+// anything else, without an error, as USE_ONEPASS_CLI always was.
+func trueOnlySetting(field func(c *config) *bool) settingValue {
+	return settingValue{
+		set: func(c *config, value string) error {
+			*field(c) = value == "true"
+			return nil
+		},
+		format: func(c *config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+func intSetting(field func(c *config) *int) settingValue {
+	return settingValue{
+		set: func(c *config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid number %q", value)
+			}
+			*field(c) = n
+			return nil
+		},
+		format: func(c *config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func stringSetting(field func(c *config) *string) settingValue {
+	return settingValue{
+		set: func(c *config, value string) error {
+			*field(c) = value
+			return nil
+		},
+		format: func(c *config) string { return strconv.Quote(*field(c)) },
+	}
+}
+
+// envRegionSetting sets the default region of an environment class; an
+// empty value leaves the class without one.
+func envRegionSetting(env string) settingValue {
+	return settingValue{
+		set: func(c *config, value string) error {
+			if value == "" {
+				delete(c.EnvRegions, env)
+				return nil
+			}
+			region, ok := validateRegion(value)
+			if !ok {
+				return fmt.Errorf("unrecognized region %q", value)
+			}
+			c.EnvRegions[env] = region
+			return nil
+		},
+		format: func(c *config) string { return strconv.Quote(c.EnvRegions[env]) },
+	}
+}
+
+func listSetting(field func(c *config) *[]string) settingValue {
+	return settingValue{
+		set: func(c *config, value string) error {
+			*field(c) = parseList(value)
+			return nil
+		},
+		format: func(c *config) string {
+			quoted := make([]string, len(*field(c)))
+			for i, item := range *field(c) {
+				quoted[i] = strconv.Quote(item)
+			}
+			return "[" + strings.Join(quoted, ", ") + "]"
+		},
+	}
+}
+
+// parseList accepts either a comma-separated string or a TOML array of
+// strings.
+func parseList(value string) []string {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	value = strings.NewReplacer(`"`, "", `'`, "").Replace(value)
+	return splitPatterns(value)
+}
+
+func configFilePath() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(homeDir(), ".config")
+	}
+	return filepath.Join(configHome, "aws-profile-selector", "config.toml")
+}
+
+// loadConfig returns the built-in defaults overridden by the shared config
+// named by remoteConfigEnv, the config file, if there is one, and then by
+// environment variables.
+func loadConfig() (config, error) {
+	c := defaultConfig()
+
+	var remote string
+	var remoteFetched bool
+	if remoteURL := os.Getenv(remoteConfigEnv); remoteURL != "" {
+		var err error
+		remote, remoteFetched, err = fetchRemoteConfig(&http.Client{Timeout: remoteConfigTimeout}, remoteURL)
+		if err != nil {
+			warn("shared config unavailable: %v", err)
+		} else if err := applyConfigFile(&c, remote, remoteConfigAllowed); err != nil {
+			// A mistake in the shared config shouldn't stop everyone
+			// who uses it.
+			warn("ignoring the rest of the shared config: %s: %v", remoteURL, err)
+			remoteFetched = false
+		}
+	}
+
+	path := configFilePath()
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return c, err
+	}
+	if err == nil {
+		if err := applyConfigFile(&c, string(content), nil); err != nil {
+			return c, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if err := applyConfigEnv(&c); err != nil {
+		return c, err
+	}
+	if remoteFetched {
+		downloadedRemoteConfig = remote
+	}
+	return c, nil
+}
+
+// renameProfileSettings moves the [profiles.<oldName>] settings of the
+// config file at path, if there are any, to newName.
+func renameProfileSettings(path, oldName, newName string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	oldPrefix, newPrefix := "profiles."+oldName, "profiles."+newName
+	err := rewriteINIFile(path, func(lines []string) ([]string, error) {
+		changed := false
+		for i, line := range lines {
+			if section, ok := iniSectionName(line); ok && section == oldPrefix {
+				lines[i] = "[" + newPrefix + "]"
+				changed = true
+			} else if key, _, ok := iniKeyValue(line); ok && strings.HasPrefix(key, oldPrefix+".") {
+				lines[i] = newPrefix + strings.TrimPrefix(strings.TrimLeft(line, " \t"), oldPrefix)
+				changed = true
+			}
+		}
+		if !changed {
+			return nil, errUnchanged
+		}
+		return lines, nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return nil
+	}
+	return err
+}
+
+// applyConfigFile applies a config file written in a small subset of TOML:
+// "key = value" lines, optionally grouped under [section] headers, with "#"
+// comments. If allowed is not nil, keys it rejects are errors.
+func applyConfigFile(c *config, content string, allowed func(key string) bool) error {
+	var section string
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, ok := iniSectionName(line); ok {
+			section = name
+			continue
+		}
+		key, value, ok := iniKeyValue(line)
+		if !ok {
+			return fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+		if section != "" {
+			key = section + "." + key
+		}
+		if allowed != nil && !allowed(key) {
+			return fmt.Errorf("line %d: %s can't be set by a shared config", lineNumber, key)
+		}
+		if err := applyConfigValue(c, key, unquote(value)); err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func applyConfigValue(c *config, key, value string) error {
+	if rest, ok := strings.CutPrefix(key, "profiles."); ok {
+		dot := strings.LastIndex(rest, ".")
+		if dot < 0 {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		name, field := rest[:dot], rest[dot+1:]
+		set, ok := profileSettings[field]
+		if !ok {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		profile := c.Profiles[name]
+		if err := set(&profile, value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		c.Profiles[name] = profile
+		return nil
+	}
+
+	for _, s := range configSettings {
+		if s.key == key {
+			if err := s.value.set(c, value); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown setting %q", key)
+}
+
+func applyConfigEnv(c *config) error {
+	for _, s := range configSettings {
+		value := os.Getenv(s.env)
+		if value == "" {
+			continue
+		}
+		if err := s.value.set(c, value); err != nil {
+			return fmt.Errorf("%s: %v", s.env, err)
+		}
+	}
+	return nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// sampleConfig returns a config file setting every setting to its value in
+// c, each annotated with its environment variable, followed by a
+// commented-out example of per-profile settings.
+func sampleConfig(c config) string {
+	var b strings.Builder
+	b.WriteString("# aws-profile-selector configuration, written by aws-login -sample-config.\n")
+	b.WriteString("# Environment variables override this file and command line flags\n")
+	b.WriteString("# override both.\n")
+
+	section := ""
+	for _, s := range configSettings {
+		key := s.key
+		if dot := strings.Index(key, "."); dot >= 0 {
+			if key[:dot] != section {
+				section = key[:dot]
+				fmt.Fprintf(&b, "\n[%s]\n", section)
+			}
+			key = key[dot+1:]
+		}
+		fmt.Fprintf(&b, "\n# environment variable: %s\n%s = %s\n", s.env, key, s.value.format(&c))
+	}
+
+	b.WriteString("\n# Per-profile settings:\n")
+	b.WriteString("# [profiles.example-prod]\n")
+	b.WriteString("# url = \"https://example.com/\"\n")
+	b.WriteString("# tags = [\"Team=platform\"]\n")
+	b.WriteString("# env = [\"AWS_ENDPOINT_URL=http://localhost:4566\"]\n")
+	b.WriteString("# icon = \"!\"\n")
+	b.WriteString("# color = \"13\"\n")
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenameProfileSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeFile(t, path, "quiet = true\nprofiles.old.color = \"13\"\n\n[profiles.old]\nurl = \"https://example.com/\"\n\n[profiles.older]\nicon = \"!\"\n")
+
+	if err := renameProfileSettings(path, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	want := "quiet = true\nprofiles.new.color = \"13\"\n\n[profiles.new]\nurl = \"https://example.com/\"\n\n[profiles.older]\nicon = \"!\"\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("config:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := renameProfileSettings(filepath.Join(t.TempDir(), "missing.toml"), "old", "new"); err != nil {
+		t.Errorf("renaming in a missing config: %v", err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	home := testHome(t)
+	setConfig(t, defaultConfig())
+	writeFile(t, filepath.Join(home, ".config", "aws-profile-selector", "config.toml"), `# sample config
+menu_height = 10
+prefix = "file-"
+safe_order = true
+
+[weights]
+substring = 7
+
+[regions]
+prod = "eu-west-1"
+
+[profiles.team-prod]
+url = "https://example.com/"
+`)
+	t.Setenv("AWS_PROFILE_SELECTOR_MENU_HEIGHT", "20")
+	t.Setenv("AWS_PROFILE_SELECTOR_SAFE_ORDER", "false")
+
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"built-in default", c.AWSCLIPath, "aws"},
+		{"file over built-in", c.Prefix, "file-"},
+		{"file section", c.Weights.Substring, 7},
+		{"built-in in a section the file sets", c.Weights.AccountID, defaultRankWeights.AccountID},
+		{"file region", c.EnvRegions[envProd], "eu-west-1"},
+		{"file profile setting", c.Profiles["team-prod"].URL, "https://example.com/"},
+		{"environment over file", c.MenuHeight, 20},
+		{"environment false over file true", c.SafeOrder, false},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestFlagsOverrideConfig(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n")
+	writeFile(t, filepath.Join(home, ".config", "aws-profile-selector", "config.toml"), "quiet = true\n")
+	args := []string{"-profile", "dev", "-no-verify", "-no-last-save"}
+
+	tests := []struct {
+		name  string
+		env   []string
+		flags []string
+		quiet bool
+	}{
+		{"file", nil, nil, true},
+		{"environment over file", []string{"AWS_PROFILE_SELECTOR_QUIET=false"}, nil, false},
+		{"flag over environment", []string{"AWS_PROFILE_SELECTOR_QUIET=false"}, []string{"-quiet"}, true},
+		{"flag over file", nil, []string{"-quiet=false"}, false},
+	}
+	for _, tt := range tests {
+		result := runMain(t, home, "", tt.env, append(tt.flags, args...)...)
+		if quiet := result.stdout == ""; result.code != 0 || quiet != tt.quiet {
+			t.Errorf("%s: exit code %d, output %q, want quiet %v", tt.name, result.code, result.stdout, tt.quiet)
+		}
+	}
+}
+
+func TestUseOnePassCLISetting(t *testing.T) {
+	for value, want := range map[string]bool{"true": true, "TRUE": false, "1": false, "yes": false, "false": false} {
+		c := defaultConfig()
+		if err := applyConfigValue(&c, "use_onepass_cli", value); err != nil {
+			t.Errorf("use_onepass_cli = %q: %v", value, err)
+		}
+		if c.UseOnePassCLI != want {
+			t.Errorf("use_onepass_cli = %q enables op run: %v, want %v", value, c.UseOnePassCLI, want)
+		}
+	}
+}
+
+func TestSampleConfig(t *testing.T) {
+	result := runMain(t, t.TempDir(), "", nil, "-sample-config")
+	if result.code != 0 {
+		t.Fatalf("exit code %d: %s", result.code, result.stdout)
+	}
+	c := defaultConfig()
+	c.Verify, c.MenuHeight, c.AWSCLIPath = false, 99, "other"
+	if err := applyConfigFile(&c, result.stdout, nil); err != nil {
+		t.Fatalf("the sample config doesn't parse: %v", err)
+	}
+	if !reflect.DeepEqual(c, defaultConfig()) {
+		t.Errorf("the sample config sets\n%+v\nwant the defaults\n%+v", c, defaultConfig())
+	}
+
+	// Every setting is documented with its environment variable.
+	for _, s := range configSettings {
+		if !strings.Contains(result.stdout, "# environment variable: "+s.env+"\n") {
+			t.Errorf("the sample config doesn't document %s", s.env)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// consoleHost returns the host of the AWS console for region. China and
+// GovCloud regions have their own partitions with their own consoles.
+func consoleHost(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "console.amazonaws.cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "console.amazonaws-us-gov.com"
+	case region == "":
+		return "console.aws.amazon.com"
+	}
+	return region + ".console.aws.amazon.com"
+}
+
+// buildConsoleURL returns the URL of destination, a path and query on
+// region's console ("" for the console home page).
+func buildConsoleURL(region, destination string) (string, error) {
+	target := &url.URL{Scheme: "https", Host: consoleHost(region), Path: "/console/home"}
+	if region != "" {
+		target.RawQuery = url.Values{"region": {region}}.Encode()
+	}
+	if destination != "" {
+		ref, err := url.Parse(destination)
+		if err != nil {
+			return "", err
+		}
+		target = target.ResolveReference(ref)
+	}
+	return target.String(), nil
+}
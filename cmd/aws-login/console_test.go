@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestBuildConsoleURL(t *testing.T) {
+	tests := []struct {
+		region, destination string
+		want                string
+	}{
+		{"", "", "https://console.aws.amazon.com/console/home"},
+		{"us-east-1", "", "https://us-east-1.console.aws.amazon.com/console/home?region=us-east-1"},
+		{"eu-west-1", "/s3/buckets?region=eu-west-1&tab=objects", "https://eu-west-1.console.aws.amazon.com/s3/buckets?region=eu-west-1&tab=objects"},
+		{"us-gov-west-1", "", "https://console.amazonaws-us-gov.com/console/home?region=us-gov-west-1"},
+		{"cn-north-1", "/ec2/home#Instances:", "https://console.amazonaws.cn/ec2/home#Instances:"},
+	}
+	for _, tt := range tests {
+		got, err := buildConsoleURL(tt.region, tt.destination)
+		if err != nil || got != tt.want {
+			t.Errorf("buildConsoleURL(%q, %q) = %q, %v, want %q", tt.region, tt.destination, got, err, tt.want)
+		}
+	}
+}
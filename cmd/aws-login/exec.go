@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// profileEnv returns environ with the variables configured for profile
+// with env in its [profiles.<name>] section, AWS_PROFILE and, when region is
+// known, the region variables set. Values already in environ are replaced,
+// and AWS_PROFILE and the region win over the configured variables.
+func profileEnv(environ []string, profile AWSProfile, region string) []string {
+	environ = setEnv(environ, cfg.Profiles[profile.Name].Env)
+	environ = awsProfileEnv(environ, profile.Name)
+	if region == "" {
+		return environ
+	}
+	return setEnv(environ, map[string]string{
+		"AWS_REGION":         region,
+		"AWS_DEFAULT_REGION": region,
+	})
+}
+
+// setEnv returns environ with vars set, replacing existing entries.
+func setEnv(environ []string, vars map[string]string) []string {
+	var result []string
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, ok := vars[name]; !ok {
+			result = append(result, entry)
+		}
+	}
+	for name, value := range vars {
+		result = append(result, name+"="+value)
+	}
+	return result
+}
+
+// execWithProfile runs args with the profile's environment, connected to
+// this process's stdio, and returns the command's exit code.
+func execWithProfile(profile AWSProfile, region string, args []string) (int, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = profileEnv(os.Environ(), profile, region)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
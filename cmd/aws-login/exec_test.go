@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecWithProfile(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n")
+
+	result := runMain(t, home, "", []string{"AWS_PROFILE=other", "AWS_REGION=us-east-1"},
+		"-profile", "dev", "--", "sh", "-c", `echo "child: $AWS_PROFILE $AWS_REGION $AWS_DEFAULT_REGION"; exit 7`)
+	if result.code != 7 {
+		t.Errorf("exit code %d, want the child's 7", result.code)
+	}
+	if want := "child: dev eu-west-1 eu-west-1\n"; !strings.Contains(result.stdout, want) {
+		t.Errorf("output %q doesn't contain %q", result.stdout, want)
+	}
+}
+
+func TestExecProfileEnv(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n\n[prod]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	// AWS_PROFILE and AWS_REGION win over the configured variables.
+	writeFile(t, filepath.Join(home, ".config", "aws-profile-selector", "config.toml"), "[profiles.dev]\nenv = [\"TF_WORKSPACE=dev\", \"KUBECONFIG=/tmp/dev.kube\", \"AWS_REGION=us-east-1\", \"AWS_PROFILE=other\"]\n")
+	script := `echo "$AWS_PROFILE $AWS_REGION $TF_WORKSPACE $KUBECONFIG"`
+
+	result := runMain(t, home, "", []string{"TF_WORKSPACE=default"}, "-profile", "dev", "--", "sh", "-c", script)
+	if want := "dev eu-west-1 dev /tmp/dev.kube\n"; !strings.HasSuffix(result.stdout, want) {
+		t.Errorf("dev: output %q doesn't end with %q", result.stdout, want)
+	}
+
+	// Profiles without env settings get only the inherited environment.
+	result = runMain(t, home, "", []string{"TF_WORKSPACE=default", "AWS_REGION=ap-south-1"}, "-profile", "prod", "--", "sh", "-c", script)
+	if want := "prod ap-south-1 default \n"; !strings.HasSuffix(result.stdout, want) {
+		t.Errorf("prod: output %q doesn't end with %q", result.stdout, want)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// selectionTrace records how the profile was chosen, for -explain.
+type selectionTrace struct {
+	// Path is the flag or prompt that chose the profile.
+	Path string
+	// Notes are details gathered along the way, such as search scores.
+	Notes        []string
+	Profile      string
+	Region       string
+	RegionSource string
+}
+
+func (t *selectionTrace) note(format string, args ...any) {
+	t.Notes = append(t.Notes, fmt.Sprintf(format, args...))
+}
+
+func (t selectionTrace) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Selected by: %s\n", t.Path)
+	for _, note := range t.Notes {
+		fmt.Fprintf(&b, "  %s\n", note)
+	}
+	fmt.Fprintf(&b, "Profile: %s\n", t.Profile)
+	fmt.Fprintf(&b, "Region: %s (from %s)\n", regionLabel(t.Region), t.RegionSource)
+	return b.String()
+}
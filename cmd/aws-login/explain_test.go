@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[billing-dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n\n[sandbox]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\nregion = us-west-2\n")
+	writeFile(t, filepath.Join(home, lastUsedFile), "sandbox")
+
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  []string
+		// Lines that belong to the other path.
+		notWant []string
+	}{
+		{
+			name:    "search",
+			stdin:   "y\n",
+			args:    []string{"-s", "billing"},
+			want:    []string{"Selected by: -s billing\n", "  score ", ": billing-dev\n", "Profile: billing-dev\n", "Region: eu-west-1", "(from profile)\n"},
+			notWant: []string{"last used", "sandbox"},
+		},
+		{
+			name:    "last used",
+			args:    []string{"-l"},
+			want:    []string{"Selected by: -l (last used profile)\n", "Profile: sandbox\n", "Region: us-west-2", "(from profile)\n"},
+			notWant: []string{"score", "billing-dev"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-explain", "-no-verify", "-no-last-save"}, tt.args...)
+			result := runMain(t, home, tt.stdin, nil, args...)
+			if result.code != 0 {
+				t.Fatalf("exit code %d, stderr %q", result.code, result.stderr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(result.stderr, want) {
+					t.Errorf("trace %q doesn't contain %q", result.stderr, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(result.stderr, notWant) {
+					t.Errorf("trace %q contains %q", result.stderr, notWant)
+				}
+			}
+		})
+	}
+}
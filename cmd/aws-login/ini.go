@@ -0,0 +1,456 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// rewriteINIFile applies edit to the lines of the file at path and writes the
+// result back, keeping the file's permissions. The new content is written to
+// a temporary file first so a failed write never leaves a truncated file.
+// Symlinks are resolved first so that the target is updated and the link
+// itself is left in place.
+func rewriteINIFile(path string, edit func(lines []string) ([]string, error)) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines, err := edit(strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"))
+	if err != nil {
+		return err
+	}
+	return writeINIFile(path, lines, info.Mode().Perm())
+}
+
+// writeINIFile replaces the file at path with lines, through a temporary
+// file so a failed write never leaves a truncated file.
+func writeINIFile(path string, lines []string, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// iniSectionName returns the section name if line is a section header.
+func iniSectionName(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		return line[1 : len(line)-1], true
+	}
+	return "", false
+}
+
+// iniKeyValue splits a "key = value" line into its trimmed key and value.
+func iniKeyValue(line string) (string, string, bool) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
+}
+
+// errUnchanged is returned by rewriteINIFile edits that found nothing to
+// change, so the file is left alone.
+var errUnchanged = errors.New("unchanged")
+
+// renameProfile renames profile oldName to newName: its section in the
+// credentials file at credentialsPath or a file it includes, its
+// [profile oldName] section in the AWS CLI config file at configPath, if
+// any, and every source_profile naming it in those files.
+func renameProfile(credentialsPath, configPath string, profiles map[string]AWSProfile, oldName, newName string) error {
+	if _, ok := profiles[oldName]; !ok {
+		return fmt.Errorf("profile %q not found", oldName)
+	}
+	if !isValidProfileName(newName) {
+		return fmt.Errorf("invalid profile name %q", newName)
+	}
+	if _, ok := profiles[newName]; ok {
+		return fmt.Errorf("profile %q already exists", newName)
+	}
+
+	paths, err := credentialsFiles(credentialsPath, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	// The profiles may be filtered, so look for newName in the files too,
+	// including [default] and profiles only the config file has.
+	for _, path := range paths {
+		taken, err := iniHasSection(path, newName)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("profile %q already exists in %s", newName, path)
+		}
+	}
+	taken, err := iniHasSection(configPath, newName, "profile "+newName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("profile %q already exists in %s", newName, configPath)
+	}
+
+	renamed := false
+	for _, path := range paths {
+		found, err := renameINISection(path, oldName, newName, oldName, newName)
+		if err != nil {
+			return err
+		}
+		renamed = renamed || found
+	}
+	if !renamed {
+		return fmt.Errorf("profile %q is not defined in %s or the files it includes", oldName, credentialsPath)
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		if _, err := renameINISection(configPath, "profile "+oldName, "profile "+newName, oldName, newName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// iniHasSection reports whether the file at path has a section named one
+// of names.
+func iniHasSection(path string, names ...string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if name, ok := iniSectionName(line); ok && slices.Contains(names, strings.TrimSpace(name)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// credentialsFiles returns path and the files it includes, recursively.
+func credentialsFiles(path string, visiting map[string]bool) ([]string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if visiting[absPath] {
+		return nil, fmt.Errorf("circular include of %s", absPath)
+	}
+	visiting[absPath] = true
+	defer delete(visiting, absPath)
+
+	content, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{absPath}
+	for _, line := range strings.Split(string(content), "\n") {
+		includePath, ok := parseIncludeDirective(line)
+		if !ok {
+			continue
+		}
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(absPath), includePath)
+		}
+		included, err := credentialsFiles(includePath, visiting)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, included...)
+	}
+	return paths, nil
+}
+
+// renameINISection renames the [oldSection] section of the file at path to
+// [newSection] and points any source_profile set to oldName at newName,
+// reporting whether the section was found. The file is only rewritten if
+// something changed.
+func renameINISection(path, oldSection, newSection, oldName, newName string) (bool, error) {
+	renamed := false
+	err := rewriteINIFile(path, func(lines []string) ([]string, error) {
+		changed := false
+		for i, line := range lines {
+			if section, ok := iniSectionName(line); ok {
+				if section == oldSection {
+					lines[i] = "[" + newSection + "]"
+					renamed, changed = true, true
+				}
+				continue
+			}
+			if key, value, ok := iniKeyValue(line); ok && key == "source_profile" && value == oldName {
+				lines[i] = line[:strings.Index(line, "=")+1] + " " + newName
+				changed = true
+			}
+		}
+		if !changed {
+			return nil, errUnchanged
+		}
+		return lines, nil
+	})
+	if errors.Is(err, errUnchanged) {
+		err = nil
+	}
+	return renamed, err
+}
+
+// profileSection returns the lines of the INI section defining profile,
+// with a line for each setting that is set.
+func profileSection(profile AWSProfile) []string {
+	lines := []string{"[" + profile.Name + "]"}
+	settings := []struct{ key, value string }{
+		{"aws_access_key_id", profile.AWSAccessKeyID},
+		{"aws_secret_access_key", profile.AWSSecretAccessKey},
+		{"region", profile.Region},
+		{"role_arn", profile.RoleARN},
+		{"source_profile", profile.SourceProfile},
+	}
+	for _, setting := range settings {
+		if setting.value != "" {
+			lines = append(lines, setting.key+" = "+setting.value)
+		}
+	}
+	return lines
+}
+
+// appendProfile adds a section for profile to the end of the file at path,
+// creating the file (readable only by its owner) if it doesn't exist.
+func appendProfile(path string, profile AWSProfile) error {
+	if !isValidProfileName(profile.Name) {
+		return fmt.Errorf("invalid profile name %q", profile.Name)
+	}
+	section := profileSection(profile)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(path, []byte(strings.Join(section, "\n")+"\n"), 0600)
+	}
+
+	return rewriteINIFile(path, func(lines []string) ([]string, error) {
+		for _, line := range lines {
+			if name, ok := iniSectionName(line); ok && name == profile.Name {
+				return nil, fmt.Errorf("profile %q already exists", profile.Name)
+			}
+		}
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(lines, section...), nil
+	})
+}
+
+// copyProfile adds a [dst] section to the end of the file at path with every
+// line of the [src] section, so settings this tool doesn't read are copied
+// too.
+func copyProfile(path string, profiles map[string]AWSProfile, src, dst string) error {
+	if _, ok := profiles[src]; !ok {
+		return fmt.Errorf("profile %q not found", src)
+	}
+	if !isValidProfileName(dst) {
+		return fmt.Errorf("invalid profile name %q", dst)
+	}
+	if _, ok := profiles[dst]; ok {
+		return fmt.Errorf("profile %q already exists", dst)
+	}
+
+	return rewriteINIFile(path, func(lines []string) ([]string, error) {
+		var body []string
+		found, inSource := false, false
+		for _, line := range lines {
+			if section, ok := iniSectionName(line); ok {
+				if section == dst {
+					return nil, fmt.Errorf("profile %q already exists", dst)
+				}
+				inSource = section == src
+				found = found || inSource
+				continue
+			}
+			if inSource {
+				body = append(body, line)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("profile %q is not defined in %s", src, path)
+		}
+
+		// Blank lines and comments at the end of the section belong to
+		// whatever follows it.
+		for len(body) > 0 {
+			last := strings.TrimSpace(body[len(body)-1])
+			if last != "" && !strings.HasPrefix(last, ";") && !strings.HasPrefix(last, "#") {
+				break
+			}
+			body = body[:len(body)-1]
+		}
+
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+dst+"]")
+		return append(lines, body...), nil
+	})
+}
+
+// iniEntry is a key of an INI section with its raw lines: the "key = value"
+// line and any indented lines nested under it.
+type iniEntry struct {
+	Key   string
+	Lines []string
+}
+
+// iniSection is a section of an INI file with its entries in file order.
+type iniSection struct {
+	Name    string
+	Entries []iniEntry
+}
+
+// readINISections returns the sections of the INI file at path, without
+// comments or blank lines. With opts.RawValues lines keep their carriage
+// returns.
+func readINISections(path string, opts parseOptions) ([]iniSection, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sections []iniSection
+	for _, line := range strings.Split(string(content), "\n") {
+		if !opts.RawValues {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if name, ok := iniSectionName(trimmed); ok {
+			sections = append(sections, iniSection{Name: strings.TrimSpace(name)})
+			continue
+		}
+		if len(sections) == 0 {
+			continue
+		}
+		section := &sections[len(sections)-1]
+		indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+		if indented && len(section.Entries) > 0 {
+			last := &section.Entries[len(section.Entries)-1]
+			last.Lines = append(last.Lines, line)
+			continue
+		}
+		if key, _, ok := iniKeyValue(line); ok {
+			section.Entries = append(section.Entries, iniEntry{Key: key, Lines: []string{line}})
+		}
+	}
+	return sections, nil
+}
+
+// mergeProfileSections combines the profiles of a config file and a
+// credentials file into credentials-file sections. Config sections are
+// named "profile <name>" (except "default"); sections that aren't profiles,
+// such as sso-session and services, follow the profiles unchanged so that
+// profiles referring to them still work. When both files set a key of the
+// same profile the credentials file wins, as it does for the AWS CLI.
+func mergeProfileSections(config, credentials []iniSection) []iniSection {
+	var merged []iniSection
+	index := make(map[string]int)
+	add := func(name string, entries []iniEntry) {
+		i, ok := index[name]
+		if !ok {
+			index[name] = len(merged)
+			merged = append(merged, iniSection{Name: name})
+			i = len(merged) - 1
+		}
+		for _, entry := range entries {
+			replaced := false
+			for j := range merged[i].Entries {
+				if merged[i].Entries[j].Key == entry.Key {
+					merged[i].Entries[j] = entry
+					replaced = true
+				}
+			}
+			if !replaced {
+				merged[i].Entries = append(merged[i].Entries, entry)
+			}
+		}
+	}
+
+	var others []iniSection
+	for _, section := range config {
+		if section.Name == "default" {
+			add(section.Name, section.Entries)
+		} else if name, ok := strings.CutPrefix(section.Name, "profile "); ok {
+			add(strings.TrimSpace(name), section.Entries)
+		} else {
+			others = append(others, section)
+		}
+	}
+	for _, section := range credentials {
+		add(section.Name, section.Entries)
+	}
+	return append(merged, others...)
+}
+
+// sectionLines renders sections as INI lines separated by blank lines.
+func sectionLines(sections []iniSection) []string {
+	var lines []string
+	for i, section := range sections {
+		if i > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+section.Name+"]")
+		for _, entry := range section.Entries {
+			lines = append(lines, entry.Lines...)
+		}
+	}
+	return lines
+}
+
+// mergeConfigFiles writes the profiles of configPath and credentialsPath,
+// merged by mergeProfileSections, to target with the credentials file's
+// permissions. A missing config file is treated as empty. Like
+// rewriteINIFile, a symlinked target is resolved so the link is kept.
+func mergeConfigFiles(configPath, credentialsPath, target string, opts parseOptions) error {
+	credentials, err := readINISections(credentialsPath, opts)
+	if err != nil {
+		return err
+	}
+	config, err := readINISections(configPath, opts)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	info, err := os.Stat(credentialsPath)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return writeINIFile(target, sectionLines(mergeProfileSections(config, credentials)), info.Mode().Perm())
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenameProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+		included    string
+		config      string
+		// The files expected after renaming old to new.
+		wantCredentials string
+		wantIncluded    string
+		wantConfig      string
+	}{
+		{
+			name:            "no dependents",
+			credentials:     "[old]\naws_access_key_id = AKIA1\n\n[other]\naws_access_key_id = AKIA2\n",
+			wantCredentials: "[new]\naws_access_key_id = AKIA1\n\n[other]\naws_access_key_id = AKIA2\n",
+		},
+		{
+			name:            "source_profile references",
+			credentials:     "[old]\naws_access_key_id = AKIA1\n\n[role]\nrole_arn = arn:aws:iam::111111111111:role/admin\nsource_profile = old\n\n[older]\nsource_profile = older-base\n",
+			wantCredentials: "[new]\naws_access_key_id = AKIA1\n\n[role]\nrole_arn = arn:aws:iam::111111111111:role/admin\nsource_profile = new\n\n[older]\nsource_profile = older-base\n",
+			config:          "[profile old]\nregion = us-east-1\n\n[profile chained]\nsource_profile=old\n",
+			wantConfig:      "[profile new]\nregion = us-east-1\n\n[profile chained]\nsource_profile= new\n",
+		},
+		{
+			name:            "included file",
+			credentials:     "; include shared\n[role]\nsource_profile = old\n",
+			wantCredentials: "; include shared\n[role]\nsource_profile = new\n",
+			included:        "[old]\naws_access_key_id = AKIA1\n",
+			wantIncluded:    "[new]\naws_access_key_id = AKIA1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			credentialsPath := filepath.Join(dir, "credentials")
+			includedPath := filepath.Join(dir, "shared")
+			configPath := filepath.Join(dir, "config")
+			writeFile(t, credentialsPath, tt.credentials)
+			if tt.included != "" {
+				writeFile(t, includedPath, tt.included)
+			}
+			if tt.config != "" {
+				writeFile(t, configPath, tt.config)
+			}
+
+			parser := newCredentialsParser(parseOptions{})
+			if err := readCredentialsFile(credentialsPath, parser, map[string]bool{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := renameProfile(credentialsPath, configPath, parser.profiles, "old", "new"); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, file := range []struct{ path, want string }{
+				{credentialsPath, tt.wantCredentials},
+				{includedPath, tt.wantIncluded},
+				{configPath, tt.wantConfig},
+			} {
+				if got := readFile(t, file.path); got != file.want {
+					t.Errorf("%s:\n%s\nwant:\n%s", filepath.Base(file.path), got, file.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameProfileErrors(t *testing.T) {
+	profiles := map[string]AWSProfile{"old": {Name: "old"}, "taken": {Name: "taken"}}
+	for _, newName := range []string{"taken", "bad name"} {
+		dir := t.TempDir()
+		credentialsPath := filepath.Join(dir, "credentials")
+		content := "[old]\n[taken]\n"
+		writeFile(t, credentialsPath, content)
+		if err := renameProfile(credentialsPath, filepath.Join(dir, "config"), profiles, "old", newName); err == nil {
+			t.Errorf("renaming to %q succeeded", newName)
+		}
+		if got := readFile(t, credentialsPath); got != content {
+			t.Errorf("renaming to %q changed the file to %q", newName, got)
+		}
+	}
+}
+
+func TestRenameProfileHiddenCollisions(t *testing.T) {
+	// Only old is loaded, as if the others were filtered out.
+	profiles := map[string]AWSProfile{"old": {Name: "old"}}
+	tests := []struct {
+		newName     string
+		credentials string
+		included    string
+		config      string
+	}{
+		{"default", "[default]\n[old]\n", "", ""},
+		{"hidden", "[old]\n; include shared\n", "[hidden]\n", ""},
+		{"cli-only", "[old]\n", "", "[profile cli-only]\nregion = us-east-1\n"},
+		{"default", "[old]\n", "", "[default]\nregion = us-east-1\n"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		credentialsPath := filepath.Join(dir, "credentials")
+		writeFile(t, credentialsPath, tt.credentials)
+		if tt.included != "" {
+			writeFile(t, filepath.Join(dir, "shared"), tt.included)
+		}
+		if tt.config != "" {
+			writeFile(t, filepath.Join(dir, "config"), tt.config)
+		}
+		err := renameProfile(credentialsPath, filepath.Join(dir, "config"), profiles, "old", tt.newName)
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("renaming to %q with credentials %q and config %q: %v, want a collision", tt.newName, tt.credentials, tt.config, err)
+		}
+		if got := readFile(t, credentialsPath); got != tt.credentials {
+			t.Errorf("renaming to %q changed the credentials to %q", tt.newName, got)
+		}
+	}
+}
+
+func TestRewriteINIFileSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "credentials")
+	link := filepath.Join(dir, "credentials")
+	writeFile(t, target, "[old]\naws_access_key_id = AKIA1\n")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	profiles := map[string]AWSProfile{"old": {Name: "old"}}
+	if err := renameProfile(link, filepath.Join(dir, "config"), profiles, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("%s is no longer a symlink: %v", link, err)
+	}
+	if got, want := readFile(t, target), "[new]\naws_access_key_id = AKIA1\n"; got != want {
+		t.Errorf("target:\n%s\nwant:\n%s", got, want)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("target permissions %v, want 0600 kept", perm)
+	}
+}
+
+func TestCopyProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	content := "[dev]\n# rotated monthly\naws_access_key_id = AKIA1\naws_secret_access_key = s1\ncli_pager =\n\n# Production\n[prod]\naws_access_key_id = AKIA2\n"
+	writeFile(t, path, content)
+	profiles := map[string]AWSProfile{"dev": {Name: "dev"}, "prod": {Name: "prod"}}
+
+	if err := copyProfile(path, profiles, "dev", "dev-copy"); err != nil {
+		t.Fatal(err)
+	}
+	want := content + "\n[dev-copy]\n# rotated monthly\naws_access_key_id = AKIA1\naws_secret_access_key = s1\ncli_pager =\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("after copying:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, dst := range []string{"prod", "dev-copy"} {
+		profiles := map[string]AWSProfile{"dev": {Name: "dev"}, "prod": {Name: "prod"}}
+		if err := copyProfile(path, profiles, "dev", dst); err == nil {
+			t.Errorf("copying over %s succeeded", dst)
+		}
+	}
+	if got := readFile(t, path); got != want {
+		t.Errorf("a rejected copy changed the file:\n%s", got)
+	}
+}
+
+func TestMergeProfileSections(t *testing.T) {
+	entry := func(key, value string) iniEntry {
+		return iniEntry{Key: key, Lines: []string{key + " = " + value}}
+	}
+	config := []iniSection{
+		{Name: "profile dev", Entries: []iniEntry{entry("region", "eu-west-1"), entry("output", "json")}},
+		{Name: "sso-session acme", Entries: []iniEntry{entry("sso_region", "us-east-1")}},
+		{Name: "default", Entries: []iniEntry{entry("region", "us-east-1")}},
+		{Name: "profile  spaced ", Entries: []iniEntry{entry("region", "us-west-2")}},
+	}
+	credentials := []iniSection{
+		{Name: "dev", Entries: []iniEntry{entry("aws_access_key_id", "AKIA1"), entry("region", "ap-south-1")}},
+		{Name: "ci", Entries: []iniEntry{entry("aws_access_key_id", "AKIA2")}},
+	}
+	want := []iniSection{
+		{Name: "dev", Entries: []iniEntry{entry("region", "ap-south-1"), entry("output", "json"), entry("aws_access_key_id", "AKIA1")}},
+		{Name: "default", Entries: []iniEntry{entry("region", "us-east-1")}},
+		{Name: "spaced", Entries: []iniEntry{entry("region", "us-west-2")}},
+		{Name: "ci", Entries: []iniEntry{entry("aws_access_key_id", "AKIA2")}},
+		{Name: "sso-session acme", Entries: []iniEntry{entry("sso_region", "us-east-1")}},
+	}
+	if got := mergeProfileSections(config, credentials); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeProfileSections =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestMergeConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	credentialsPath := filepath.Join(dir, "credentials")
+	target := filepath.Join(dir, "merged.ini")
+	writeFile(t, configPath, "[default]\nregion = us-east-1\n\n[profile dev]\nregion = eu-west-1\noutput = json\n\n[sso-session acme]\nsso_region = us-east-1\n\n[profile only-config]\nrole_arn = arn:aws:iam::111111111111:role/admin\nsource_profile = dev\n")
+	writeFile(t, credentialsPath, "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-west-2\n\n[only-credentials]\naws_access_key_id = AKIA2\n")
+	if err := os.Chmod(credentialsPath, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mergeConfigFiles(configPath, credentialsPath, target, parseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// dev keeps its config-only key, and takes the credentials file's
+	// region in place of the config's.
+	want := "[default]\nregion = us-east-1\n\n[dev]\nregion = us-west-2\noutput = json\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n\n[only-config]\nrole_arn = arn:aws:iam::111111111111:role/admin\nsource_profile = dev\n\n[only-credentials]\naws_access_key_id = AKIA2\n\n[sso-session acme]\nsso_region = us-east-1\n"
+	if got := readFile(t, target); got != want {
+		t.Errorf("merged:\n%s\nwant:\n%s", got, want)
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0640 {
+		t.Errorf("merged file permissions %v, want the credentials file's 0640", perm)
+	}
+
+	// Without a config file, the credentials are written as they are.
+	if err := mergeConfigFiles(filepath.Join(dir, "missing"), credentialsPath, target, parseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readFile(t, target), readFile(t, credentialsPath); got != want {
+		t.Errorf("merged without config:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A symlinked target is written through, keeping the link.
+	dotfile := filepath.Join(dir, "dotfiles", "merged.ini")
+	writeFile(t, dotfile, "")
+	link := filepath.Join(dir, "link.ini")
+	if err := os.Symlink(dotfile, link); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+	if err := mergeConfigFiles(configPath, credentialsPath, link, parseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("the symlink was replaced: %v", err)
+	}
+	if got := readFile(t, dotfile); got != want {
+		t.Errorf("symlink target:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateLockFile = ".aws-profile-selector.lock"
+
+const (
+	lockTimeout      = 2 * time.Second
+	lockPollInterval = 10 * time.Millisecond
+	// A lock older than lockStaleAfter was left behind by a process that
+	// died while holding it and is removed.
+	lockStaleAfter = 30 * time.Second
+)
+
+// acquireLock takes an advisory lock by exclusively creating path, waiting
+// up to timeout for another holder to release it. It works the same on every
+// platform, unlike flock. The returned function releases the lock.
+func acquireLock(path string, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStaleAfter {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", path)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// withStateLock runs fn while holding the lock that guards the state files
+// in the home directory.
+func withStateLock(fn func() error) error {
+	unlock, err := acquireLock(filepath.Join(homeDir(), stateLockFile), lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fn()
+}
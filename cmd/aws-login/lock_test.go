@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestStateConcurrentWrites(t *testing.T) {
+	testHome(t)
+	const writes = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writes)
+	for _, prefix := range []string{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				errs <- recordProfileUse(fmt.Sprintf("%s-%d", prefix, i))
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every use must be in the history: a write made without the lock
+	// would overwrite the other goroutine's.
+	var names []string
+	for _, entry := range getProfileHistory() {
+		names = append(names, entry.Name)
+	}
+	if len(names) != 2*writes {
+		t.Errorf("history has %d entries, want %d: %v", len(names), 2*writes, names)
+	}
+	if last := getLastUsedProfile(); len(names) == 0 || last != names[0] {
+		t.Errorf("last used profile %q isn't the newest history entry in %v", last, names)
+	}
+	for _, prefix := range []string{"a", "b"} {
+		if !slices.Contains(names, fmt.Sprintf("%s-%d", prefix, writes-1)) {
+			t.Errorf("history is missing %s-%d", prefix, writes-1)
+		}
+	}
+}
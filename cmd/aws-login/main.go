@@ -2,183 +2,1915 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
 )
 
+// AWSProfile is a profile from the credentials file. Its JSON form is the
+// one written to the profile cache, with the credentials redacted.
 type AWSProfile struct {
-	Name               string
-	AWSAccountID       string
-	AWSAccessKeyID     string
-	AWSSecretAccessKey string
-	Region             string
-	RoleARN            string
-	SourceProfile      string
+	Name               string `json:"name"`
+	AWSAccountID       string `json:"aws_account_id,omitempty"`
+	AWSAccessKeyID     string `json:"aws_access_key_id,omitempty"`
+	AWSSecretAccessKey string `json:"aws_secret_access_key,omitempty"`
+	Region             string `json:"region,omitempty"`
+	RoleARN            string `json:"role_arn,omitempty"`
+	SourceProfile      string `json:"source_profile,omitempty"`
+	SSOStartURL        string `json:"sso_start_url,omitempty"`
+	SSOSession         string `json:"sso_session,omitempty"`
+	SSOAccountID       string `json:"sso_account_id,omitempty"`
+	SSORoleName        string `json:"sso_role_name,omitempty"`
+	CredentialProcess  string `json:"credential_process,omitempty"`
+	Description        string `json:"description,omitempty"`
 }
 
 const lastUsedFile = ".aws-profile-selector-last"
+const pinnedFile = ".aws-profile-selector-pins"
+const historyFile = ".aws-profile-selector-history"
+const cacheFile = ".aws-profile-selector-cache.json"
+const regionsFile = ".aws-profile-selector-regions"
+
+// maxHistory is the number of distinct profiles kept in the history file.
+const maxHistory = 50
+
+// Exit codes, also reported as "code" in -json error output.
+const (
+	exitError        = 1
+	exitFileNotFound = 3
+	exitNoProfiles   = 4
+)
+
+// useOptions controls how selectAndUseProfile reports and records a
+// selection.
+type useOptions struct {
+	JSONOutput   bool
+	SaveLastUsed bool
+	Verify       bool
+	Probe        bool
+	PrintARN     bool
+	Verbose      bool
+	// Duration, when non-zero, verifies role profiles with an explicit
+	// assume-role call for a session of that many seconds.
+	Duration int
+	// Expires reports how long the session has left after verifying it.
+	Expires bool
+	// AuditLog, if set, is the file a record of the run is appended to.
+	AuditLog string
+	// FreshWithin, when non-zero, reuses a verification of the profile
+	// younger than this instead of calling STS again.
+	FreshWithin time.Duration
+	// Region is the region the profile will use, as resolved by main.
+	Region string
+}
+
+// callerIdentity is the response of `aws sts get-caller-identity`.
+type callerIdentity struct {
+	UserID  string `json:"UserId"`
+	Account string `json:"Account"`
+	Arn     string `json:"Arn"`
+}
+
+type jsonError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+}
+
+type jsonResult struct {
+	Profile  string          `json:"profile"`
+	Region   string          `json:"region"`
+	Identity json.RawMessage `json:"identity,omitempty"`
+}
+
+// whichResult is the -which -json output. Source is one of the
+// profileSource constants.
+type whichResult struct {
+	Profile string `json:"profile"`
+	Region  string `json:"region"`
+	Source  string `json:"source"`
+}
 
 func main() {
 	var useLastProfile bool
 	var searchTerm string
+	var interactiveSearch bool
+	var pinName string
+	var unpinName string
+	var noLastSave bool
+	var profileName string
+	var renameTo string
+	var probe bool
+	var printARN bool
+	var diff bool
+	var accountSelect string
+	var noVerify bool
+	var noOnePass bool
+	var lastN int
+	var open bool
+	var refreshCache bool
+	var fromCache bool
+	var fromSSOCache bool
+	var list bool
+	var which bool
+	var verbose bool
+	var configureRegion string
+	var duration int
+	var execArgs []string
+	var regionOnly bool
+	var touch string
+	var export bool
+	var clearExport bool
+	var benchmarkIterations int
+	var newProfile bool
+	var mirrorTo string
+	var jsonSchemaOutput bool
+	var sampleConfigOutput bool
+	var watchSeconds int
+	var explain bool
+	var verifyAllRegions bool
+	var mergeConfigTarget string
+	var noTrim bool
+	var checkOnePassCLI bool
+	var fromStdin bool
+	var expires bool
+	var summary bool
+	var failOnAmbiguous bool
+	var auditLog string
+	var freshWithin time.Duration
+	var printRegion bool
+	var grepPattern string
+	var chain bool
+	var stats bool
+
+	if _, err := resolveHomeDir(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	var err error
+	cfg, err = loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(exitError)
+	}
 
 	flag.BoolVar(&useLastProfile, "l", false, "Use the last saved profile")
 	flag.StringVar(&searchTerm, "s", "", "Search term for profile selection")
+	flag.BoolVar(&failOnAmbiguous, "fail-on-ambiguous", false, "With -s, fail instead of suggesting a profile when several share the top score")
+	flag.BoolVar(&interactiveSearch, "i", false, "Type to filter profiles and press enter to select")
+	flag.StringVar(&pinName, "pin", "", "Pin a profile to the top of the list")
+	flag.StringVar(&unpinName, "unpin", "", "Remove a profile from the pinned list")
+	flag.BoolVar(&cfg.JSON, "json", cfg.JSON, "Print the result (or error) as JSON")
+	flag.BoolVar(&sampleConfigOutput, "sample-config", false, "Print an annotated config file with the default settings")
+	flag.BoolVar(&jsonSchemaOutput, "json-schema", false, "Print the JSON Schema of the -json output")
+	flag.BoolVar(&cfg.Quiet, "quiet", cfg.Quiet, "Print only errors and requested data")
+	flag.BoolVar(&cfg.Strict, "strict", cfg.Strict, "Exit with an error if any warning is printed")
+	flag.BoolVar(&checkOnePassCLI, "check-onepass", false, "Check that the 1Password CLI is installed and signed in")
+	flag.BoolVar(&noOnePass, "no-onepass", !cfg.UseOnePassCLI, "Run the AWS CLI directly instead of through op run")
+	flag.BoolVar(&cfg.UseAWSCLI, "use-aws-cli", cfg.UseAWSCLI, "Discover profiles with aws configure list-profiles")
+	flag.IntVar(&cfg.MenuHeight, "height", cfg.MenuHeight, "Number of rows in the profile list (default: fit the terminal)")
+	flag.StringVar(&cfg.Prefix, "prefix", cfg.Prefix, "Only offer profiles whose names start with this prefix")
+	flag.BoolVar(&cfg.ASCII, "ascii", cfg.ASCII, "Mark profiles with ASCII instead of symbols")
+	flag.BoolVar(&cfg.StripPrefix, "strip-prefix", cfg.StripPrefix, "Hide the -prefix in the profile list")
+	flag.BoolVar(&cfg.SafeOrder, "safe-order", cfg.SafeOrder, "List prod profiles after all others")
+	flag.BoolVar(&cfg.AccountNames, "account-names", cfg.AccountNames, "Show account names from AWS Organizations in the profile list")
+	flag.BoolVar(&noLastSave, "no-last-save", false, "Don't remember the selected profile as the last used one")
+	flag.BoolVar(&cfg.ReadOnly, "read-only", cfg.ReadOnly, "Never write any file; reject flags that would")
+	flag.StringVar(&profileName, "profile", "", "Use the named profile without prompting")
+	flag.StringVar(&renameTo, "rename", "", "Rename the profile given by -profile")
+	flag.StringVar(&mergeConfigTarget, "merge-config", "", "Write the profiles of ~/.aws/config and ~/.aws/credentials merged into one file")
+	flag.BoolVar(&cfg.ExpandEnv, "expand-env", cfg.ExpandEnv, "Expand $VAR and ${VAR} in values read from the credentials file")
+	flag.BoolVar(&noTrim, "no-trim", false, "Keep trailing whitespace in values read from the credentials and config files")
+	flag.StringVar(&mirrorTo, "mirror", "", "Copy the profile given by -profile under a new name")
+	flag.BoolVar(&newProfile, "new", false, "Add a profile to the credentials file interactively")
+	flag.BoolVar(&probe, "probe", false, "Verify the profile and print only its account id")
+	flag.BoolVar(&printARN, "print-arn", false, "Verify the profile and print only the caller ARN")
+	flag.BoolVar(&chain, "chain", false, "Choose which profile in the selected profile's source_profile chain to use")
+	flag.BoolVar(&printRegion, "print-region", false, "Print only the profile's region, without verifying it")
+	flag.BoolVar(&diff, "diff", false, "Compare the two profiles given as arguments")
+	flag.BoolVar(&cfg.Confirm, "confirm", cfg.Confirm, "Show a summary and ask for confirmation before using the profile")
+	flag.BoolVar(&cfg.NoPrompt, "no-prompt", cfg.NoPrompt, "Fail instead of prompting, including to accept the -s suggestion, when the profile isn't given by -profile or -l")
+	flag.BoolVar(&cfg.ConfirmAccountSwitch, "confirm-account-switch", cfg.ConfirmAccountSwitch, "Ask for confirmation only when the profile is in another account than the active one")
+	flag.StringVar(&accountSelect, "account-select", "", "Select the profile for an account id, prompting if several match")
+	flag.BoolVar(&noVerify, "no-verify", !cfg.Verify, "Skip the aws sts get-caller-identity check")
+	flag.IntVar(&lastN, "last-n", 0, "List the last N distinct profiles used")
+	flag.BoolVar(&stats, "stats", false, "Show how many times each profile has been selected, most used first")
+	flag.BoolVar(&open, "open", false, "Open the URL configured for the profile given by -profile, or else the AWS console")
+	flag.BoolVar(&refreshCache, "refresh-cache", false, "Parse the credentials file and write the profile cache")
+	flag.BoolVar(&fromCache, "from-cache", false, "Read profiles from the cache written by -refresh-cache")
+	flag.BoolVar(&fromStdin, "stdin", false, "Read profiles in credentials file format from stdin")
+	flag.BoolVar(&fromSSOCache, "from-sso-cache", false, "Offer the accounts and roles available with cached AWS SSO logins")
+	flag.BoolVar(&list, "list", false, "List profile names")
+	flag.StringVar(&grepPattern, "grep", "", "List the profile names matching this regular expression")
+	flag.BoolVar(&summary, "summary", false, "Count the profiles per account and per environment")
+	flag.BoolVar(&which, "which", false, "Print the active profile (AWS_PROFILE, else the last used one)")
+	flag.BoolVar(&verifyAllRegions, "verify-all-regions", false, "Verify the profile in each of the verify_regions from the config")
+	flag.StringVar(&auditLog, "audit-log", "", "Append a JSON record of the selected profile and its verification to this file")
+	flag.BoolVar(&explain, "explain", false, "Print how the profile and region were chosen")
+	flag.BoolVar(&verbose, "verbose", false, "Print details about the selected profile")
+	flag.StringVar(&configureRegion, "configure-region", "", "Set the region of the profile given by -profile with aws configure")
+	flag.IntVar(&watchSeconds, "watch", 0, "Verify the selected profile every N seconds until interrupted")
+	flag.DurationVar(&freshWithin, "fresh-within", 0, "Skip verifying the profile if it was verified successfully within this long, e.g. 5m")
+	flag.BoolVar(&expires, "expires", false, "Verify the profile and report how long its session has left")
+	flag.IntVar(&duration, "duration", 0, "Session duration in seconds for the assume-role call verifying a role profile")
+	flag.StringVar(&regionFlag, "region", "", "Region to use instead of the profile's region")
+	flag.BoolVar(&cfg.RequireRegion, "require-region", cfg.RequireRegion, "Fail instead of continuing when the profile has no region")
+	flag.IntVar(&benchmarkIterations, "benchmark-parse", 0, "Parse the credentials file N times and report timings")
+	flag.BoolVar(&export, "export", false, "Print an export command for eval instead of informational output")
+	flag.BoolVar(&clearExport, "clear-export", false, "Print the unset command that undoes -export")
+	flag.StringVar(&touch, "touch", "", "Record a profile as just used without verifying it")
+	flag.BoolVar(&regionOnly, "region-only", false, "Change and remember the region of the active profile without selecting a profile")
+	flag.Usage = usage
 	flag.Parse()
+	cfg.Verify = !noVerify
+	cfg.UseOnePassCLI = !noOnePass
+	cacheRemoteConfig()
+	parseOpts := parseOptions{RawValues: noTrim, ExpandEnv: cfg.ExpandEnv}
+	cfg.ASCII = cfg.ASCII || asciiTerminal(os.Getenv)
+	if args := flag.Args(); len(args) > 0 && os.Args[len(os.Args)-len(args)-1] == "--" {
+		execArgs = args
+	}
+	if export || cfg.JSON || grepPattern != "" {
+		// stdout is for machine-readable output only.
+		infoOutput = os.Stderr
+	}
+
+	fail := func(code int, message string) {
+		if cfg.JSON {
+			printJSONError(code, message)
+		} else {
+			fmt.Fprintln(infoOutput, message)
+		}
+		os.Exit(code)
+	}
+
+	var grepRegexp *regexp.Regexp
+	if grepPattern != "" {
+		grepRegexp, err = regexp.Compile(grepPattern)
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Invalid -grep pattern %q: %v", grepPattern, err))
+		}
+	}
+
+	// checkStrict fails the run under -strict once any warning has been
+	// printed.
+	checkStrict := func() {
+		if cfg.Strict && len(warnings) > 0 {
+			fail(exitError, fmt.Sprintf("Error: %d warning(s) in strict mode", len(warnings)))
+		}
+	}
+
+	for _, envVar := range regionEnvVars {
+		if conflict, message := regionEnvConflict(regionFlag, envVar, os.Getenv(envVar)); conflict {
+			warn("%s", message)
+		}
+	}
+
+	if cfg.ReadOnly {
+		flag.Visit(func(f *flag.Flag) {
+			if mutatingFlags[f.Name] {
+				fail(exitError, fmt.Sprintf("-%s is not allowed in read-only mode", f.Name))
+			}
+		})
+	}
+
+	if clearExport {
+		fmt.Println(clearExportLine)
+		return
+	}
+	if checkOnePassCLI {
+		account, err := checkOnePass()
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		fmt.Println(account)
+		return
+	}
+	if sampleConfigOutput {
+		fmt.Print(sampleConfig(defaultConfig()))
+		return
+	}
+	if jsonSchemaOutput {
+		schema, err := json.MarshalIndent(outputSchema(), "", "  ")
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		fmt.Println(string(schema))
+		return
+	}
+	if benchmarkIterations > 0 {
+		total, err := benchmarkParse(benchmarkIterations, func() error {
+			_, err := loadProfiles(parseOpts)
+			return err
+		})
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error reading AWS credentials: %v", err))
+		}
+		fmt.Fprintf(os.Stderr, "Parsed %d times in %v (%v per parse)\n",
+			benchmarkIterations, total, total/time.Duration(benchmarkIterations))
+		return
+	}
+
+	if duration != 0 {
+		if err := validateSessionDuration(duration); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+	}
+
+	if mergeConfigTarget != "" {
+		if err := mergeConfigFiles(awsConfigFilePath(), credentialsFilePath(), mergeConfigTarget, parseOpts); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		logInfo("Wrote merged profiles to %s\n", mergeConfigTarget)
+		return
+	}
+
+	if newProfile {
+		existing, err := loadProfiles(parseOpts)
+		if err != nil && !os.IsNotExist(err) {
+			fail(exitError, fmt.Sprintf("Error reading AWS credentials: %v", err))
+		}
+		profile, err := showNewProfileWizard(existing)
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		if err := appendProfile(credentialsFilePath(), profile); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		logInfo("Added profile %s to %s\n", profile.Name, credentialsFilePath())
+		return
+	}
 
-	profiles, err := loadProfiles()
+	var profiles map[string]AWSProfile
+	if fromCache {
+		profiles, err = loadCachedProfiles()
+	} else if fromStdin {
+		profiles, err = loadProfilesFromReader(os.Stdin, parseOpts)
+	} else if fromSSOCache {
+		profiles, err = loadProfilesFromSSOCache()
+	} else if cfg.UseAWSCLI {
+		if err := checkAWSCLI(cfg.AWSCLIMinVersion); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		profiles, err = loadProfilesFromAWSCLI()
+	} else {
+		profiles, err = loadProfiles(parseOpts)
+		if len(profiles) == 0 && (err == nil || os.IsNotExist(err)) {
+			// On EC2, ECS and the like credentials usually come from the
+			// environment instead of a credentials file.
+			if ambient := ambientProfiles(); ambient != nil {
+				profiles, err = ambient, nil
+			}
+		}
+	}
 	if err != nil {
-		fmt.Printf("Error reading AWS credentials: %v\n", err)
-		os.Exit(1)
+		if os.IsNotExist(err) {
+			fail(exitFileNotFound, fmt.Sprintf("Error reading AWS credentials: %v", err))
+		}
+		fail(exitError, fmt.Sprintf("Error reading AWS credentials: %v", err))
+	}
+	profiles = filterByPrefix(profiles, cfg.Prefix)
+	if len(profiles) == 0 {
+		fail(exitNoProfiles, "No AWS profiles found.")
+	}
+	for _, profile := range filterProfiles(profiles, "") {
+		if !isCompleteProfile(profile) {
+			warn("profile %s has no usable credentials", profile.Name)
+		}
+	}
+	for _, names := range findDuplicateAccessKeys(profiles) {
+		warn("profiles %s share the same aws_access_key_id", strings.Join(names, ", "))
+	}
+	for _, name := range findDanglingSourceProfiles(profiles) {
+		warn("profile %s has source_profile %s, which is not defined", name, profiles[name].SourceProfile)
+	}
+	checkStrict()
+
+	if refreshCache {
+		if err := saveCachedProfiles(profiles); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		logInfo("Cached %d profiles\n", len(profiles))
+		return
+	}
+
+	if list {
+		for _, profile := range filterProfiles(profiles, "") {
+			fmt.Println(profile.Name)
+		}
+		return
+	}
+	if grepRegexp != nil {
+		matched := false
+		for _, profile := range filterProfiles(profiles, "") {
+			if grepRegexp.MatchString(profile.Name) {
+				fmt.Println(profile.Name)
+				matched = true
+			}
+		}
+		if !matched {
+			// Like grep, exit with an error so scripts can tell.
+			fail(exitError, fmt.Sprintf("No profiles match %q", grepPattern))
+		}
+		return
+	}
+	if summary {
+		fmt.Print(profileSummary(profiles))
+		return
+	}
+
+	if which {
+		name, source := activeProfileSource()
+		if cfg.JSON {
+			result := whichResult{Profile: name, Source: source}
+			if name != "" {
+				profile, ok := profiles[name]
+				if !ok {
+					profile = AWSProfile{Name: name}
+				}
+				result.Region = resolveRegion(profile)
+			}
+			if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+				fail(exitError, fmt.Sprintf("Error: %v", err))
+			}
+		} else if name != "" {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	if touch != "" {
+		if _, ok := profiles[touch]; !ok {
+			fail(exitError, fmt.Sprintf("Profile %q not found.", touch))
+		}
+		if err := recordProfileUse(touch); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		return
+	}
+
+	if regionOnly {
+		name := activeProfile()
+		if name == "" {
+			fail(exitError, "No active profile found.")
+		}
+		region, err := changeProfileRegion(name)
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		logInfo("Region for %s set to %s\n", name, region)
+		return
+	}
+
+	if pinName != "" || unpinName != "" {
+		if err := updatePinnedProfiles(profiles, pinName, unpinName); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		return
+	}
+
+	if stats {
+		fmt.Print(usageStats(getUsageCounts()))
+		return
+	}
+
+	if lastN > 0 {
+		for _, entry := range lastNProfiles(getProfileHistory(), lastN) {
+			fmt.Println(entry.Name)
+		}
+		return
+	}
+
+	if diff {
+		args := flag.Args()
+		if len(args) != 2 {
+			fail(exitError, "-diff requires two profile names")
+		}
+		for _, name := range args {
+			if _, ok := profiles[name]; !ok {
+				fail(exitError, fmt.Sprintf("Profile %q not found.", name))
+			}
+		}
+		fmt.Print(diffProfiles(profiles[args[0]], profiles[args[1]]))
+		return
+	}
+
+	if open {
+		if profileName == "" {
+			fail(exitError, "-open requires -profile")
+		}
+		profile, ok := profiles[profileName]
+		if !ok {
+			fail(exitError, fmt.Sprintf("Profile %q not found.", profileName))
+		}
+		url := cfg.Profiles[profileName].URL
+		if url == "" {
+			// Without a configured url, open the console in the profile's
+			// region.
+			url, err = buildConsoleURL(resolveRegion(profile), "")
+			if err != nil {
+				fail(exitError, fmt.Sprintf("Error: %v", err))
+			}
+		}
+		openURL(url)
+		return
+	}
+
+	if configureRegion != "" {
+		if profileName == "" {
+			fail(exitError, "-configure-region requires -profile")
+		}
+		region, ok := validateRegion(configureRegion)
+		if !ok {
+			fail(exitError, fmt.Sprintf("Unrecognized region %q.", configureRegion))
+		}
+		if output, err := configureRegionCommand(profileName, region).CombinedOutput(); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v: %s", err, strings.TrimSpace(string(output))))
+		}
+		logInfo("Set region of %s to %s\n", profileName, region)
+		return
+	}
+
+	if renameTo != "" {
+		if profileName == "" {
+			fail(exitError, "-rename requires -profile")
+		}
+		if err := renameProfile(credentialsFilePath(), awsConfigFilePath(), profiles, profileName, renameTo); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		if err := renameProfileSettings(configFilePath(), profileName, renameTo); err != nil {
+			warn("error renaming the settings of %s in %s: %v", profileName, configFilePath(), err)
+		}
+		if err := renameProfileState(profileName, renameTo); err != nil {
+			warn("error moving what was remembered about %s to %s: %v", profileName, renameTo, err)
+		}
+		logInfo("Renamed profile %s to %s\n", profileName, renameTo)
+		return
+	}
+
+	if mirrorTo != "" {
+		if profileName == "" {
+			fail(exitError, "-mirror requires -profile")
+		}
+		if err := copyProfile(credentialsFilePath(), profiles, profileName, mirrorTo); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		logInfo("Copied profile %s to %s\n", profileName, mirrorTo)
+		return
 	}
 
 	var selectedProfile string
+	var trace selectionTrace
 
-	if useLastProfile {
+	if profileName != "" {
+		if _, ok := profiles[profileName]; !ok {
+			fail(exitError, fmt.Sprintf("Profile %q not found.", profileName))
+		}
+		selectedProfile = profileName
+		trace.Path = "-profile"
+	} else if accountSelect != "" {
+		matches := filterByAccount(profiles, accountSelect)
+		trace.Path = "-account-select " + accountSelect
+		trace.note("%d profile(s) for the account", len(matches))
+		switch len(matches) {
+		case 0:
+			fail(exitError, fmt.Sprintf("No profiles found for account %s.", accountSelect))
+		case 1:
+			for name := range matches {
+				selectedProfile = name
+			}
+		default:
+			profiles = matches
+		}
+	} else if useLastProfile {
 		selectedProfile = getLastUsedProfile()
 		if selectedProfile == "" {
-			fmt.Println("No last used profile found.")
-			os.Exit(1)
+			fail(exitError, "No last used profile found.")
+		}
+		trace.Path = "-l (last used profile)"
+		if _, ok := profiles[selectedProfile]; !ok {
+			// The profile was renamed or removed since it was last used.
+			if !term.IsTerminal(os.Stdin.Fd()) {
+				fail(exitError, fmt.Sprintf("Last used profile %q no longer exists.", selectedProfile))
+			}
+			warn("last used profile %q no longer exists", selectedProfile)
+			trace.note("last used profile %s no longer exists", selectedProfile)
+			selectedProfile = ""
 		}
 	} else if searchTerm != "" {
-		selectedProfile = handleProfileSearch(profiles, searchTerm)
+		trace.Path = "-s " + searchTerm
+		var matches []AWSProfile
+		if cfg.RankCommand != "" {
+			ranked, err := externalRank(cfg.RankCommand, profiles, searchTerm)
+			if err != nil {
+				warn("%v; using the built-in ranking", err)
+			} else {
+				matches = ranked
+				for i, match := range matches {
+					trace.note("rank command placed %s at %d", match.Name, i+1)
+				}
+			}
+		}
+		if matches == nil {
+			matches = searchProfiles(profiles, searchTerm)
+			var scores []int
+			for _, match := range matches {
+				score := rankProfile(match, strings.ToLower(searchTerm), cfg.Weights, cfg.MatchAllTerms)
+				scores = append(scores, score)
+				trace.note("score %d: %s", score, match.Name)
+			}
+			if tied := topScoreTies(matches, scores); failOnAmbiguous && len(tied) > 1 {
+				fail(exitError, fmt.Sprintf("%q matches several profiles equally well: %s", searchTerm, strings.Join(tied, ", ")))
+			}
+		}
+		name, err := handleProfileSearch(matches)
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		selectedProfile = name
+		if selectedProfile == "" {
+			trace.note("suggestion declined")
+		}
+	} else if name := os.Getenv("AWS_PROFILE"); name != "" && !interactiveSearch && !term.IsTerminal(os.Stdin.Fd()) {
+		// Without a terminal to prompt on, a profile the caller already
+		// exported is the one they mean.
+		if _, ok := profiles[name]; ok {
+			selectedProfile = name
+			trace.Path = "AWS_PROFILE (no terminal to prompt on)"
+		}
 	}
 
 	if selectedProfile == "" {
+		if cfg.AccountNames {
+			orgProfile := activeProfile()
+			if orgProfile == "" {
+				orgProfile = "default"
+			}
+			names, err := loadAccountNames(orgProfile)
+			if err != nil {
+				warn("account names unavailable: %v", err)
+			}
+			accountNames = names
+		}
+
 		var err error
-		selectedProfile, err = showProfileSelectionPrompt(profiles)
+		if interactiveSearch {
+			trace.Path = "interactive search (-i)"
+			selectedProfile, err = showInteractiveSearchPrompt(profiles)
+		} else {
+			trace.Path = "selection prompt"
+			if lastUsed := getLastUsedProfile(); lastUsed != "" {
+				trace.note("highlighted the last used profile %s first", lastUsed)
+			}
+			selectedProfile, err = showProfileSelectionPrompt(profiles)
+		}
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+	}
+
+	if selectedProfile == "" {
+		fail(exitError, "No profile selected. Exiting.")
+	}
+
+	profile, ok := profiles[selectedProfile]
+	if !ok {
+		profile = AWSProfile{Name: selectedProfile}
+	}
+
+	if chain {
+		if options := chainOptions(profiles, profile.Name); len(options) > 1 {
+			name, err := showChainSelectionPrompt(profile.Name, options)
+			if err != nil {
+				fail(exitError, fmt.Sprintf("Error: %v", err))
+			}
+			trace.note("chose %s from the source_profile chain", name)
+			profile = profiles[name]
+		}
+	}
+
+	// Resolved once, so that its warnings and AWS CLI call aren't repeated.
+	region, regionSource := resolveRegionSource(profile)
+
+	// Checked before anything uses the profile, including -watch and
+	// commands run after "--".
+	if cfg.RequireRegion && region == "" {
+		fail(exitError, fmt.Sprintf("Error: no region for profile %s; pass -region, set one with -configure-region, or configure a default region for its environment", profile.Name))
+	}
+
+	if printRegion {
+		fmt.Println(region)
+		return
+	}
+
+	confirm := cfg.Confirm
+	if !confirm && cfg.ConfirmAccountSwitch {
+		verifications := loadVerifications()
+		from := profileAccount(profiles, verifications, activeProfile())
+		to := profileAccount(profiles, verifications, profile.Name)
+		confirm = accountSwitch(from, to)
+		if confirm {
+			trace.note("switching from account %s to %s", from, to)
+		}
+	}
+	if confirm {
+		confirmed, err := askConfirmation(profile, region)
+		if err != nil && !errors.Is(err, huh.ErrUserAborted) {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		if !confirmed {
+			if cfg.JSON {
+				fail(exitError, "Cancelled.")
+			}
+			logInfo("Cancelled.\n")
+			return
+		}
+	}
+
+	if fromSSOCache {
+		// The profile only exists in the SSO cache until the AWS CLI is
+		// told about it.
+		if cfg.ReadOnly {
+			fail(exitError, fmt.Sprintf("Error: using %s requires adding it to %s, which -read-only prevents", profile.Name, awsConfigFilePath()))
+		}
+		added, err := saveSSOProfile(awsConfigFilePath(), profile)
+		if err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		if added {
+			logInfo("Added profile %s to %s\n", profile.Name, awsConfigFilePath())
+		}
+	}
+
+	// The AWS CLI has no profile setting for session tags, so they are only
+	// sent with the assume-role call made for -duration.
+	if tags := cfg.Profiles[profile.Name].Tags; len(tags) > 0 {
+		switch {
+		case profile.RoleARN == "":
+			warn("profile %s has session tags but doesn't assume a role; they aren't sent", profile.Name)
+		case duration == 0:
+			warn("session tags for %s are only sent with -duration; they aren't sent this time", profile.Name)
+		}
+	}
+
+	if duration > 0 {
+		if err := validateChainedDuration(profile, profiles, duration); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+	}
+
+	if explain {
+		trace.Profile = profile.Name
+		trace.Region, trace.RegionSource = region, regionSource
+		fmt.Fprint(os.Stderr, trace)
+	}
+
+	if cfg.Strict {
+		checkStrict()
+	}
+
+	if verifyAllRegions {
+		if len(cfg.VerifyRegions) == 0 {
+			fail(exitError, "-verify-all-regions requires verify_regions in the config")
+		}
+		summary, ok := summarizeRegionChecks(verifyRegions(profile.Name, cfg.VerifyRegions))
+		fmt.Fprint(infoOutput, summary)
+		if !ok {
+			fail(exitError, fmt.Sprintf("Profile %s failed verification in some regions", profile.Name))
+		}
+		return
+	}
+
+	if watchSeconds > 0 {
+		watchIdentity(profile.Name, time.Duration(watchSeconds)*time.Second)
+		return
+	}
+
+	if len(execArgs) > 0 {
+		exitCode, err := execWithProfile(profile, region, execArgs)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+		os.Exit(exitCode)
+	}
+
+	opts := useOptions{
+		JSONOutput:   cfg.JSON,
+		Region:       region,
+		Verify:       verificationRequired(profileEnvironment(profile.Name), cfg.Verify) || probe || printARN || expires,
+		SaveLastUsed: !noLastSave && !probe && !printARN && !cfg.ReadOnly,
+		Probe:        probe,
+		PrintARN:     printARN,
+		Verbose:      verbose,
+		Duration:     duration,
+		Expires:      expires,
+		AuditLog:     auditLog,
+		FreshWithin:  freshWithin,
+	}
+	if opts.Verify && profile.Name != ambientProfile {
+		if err := checkAWSCLI(cfg.AWSCLIMinVersion); err != nil {
+			fail(exitError, fmt.Sprintf("Error: %v", err))
+		}
+	}
+	if err := selectAndUseProfile(profile, opts); err != nil {
+		code := exitError
+		var exitErr *exec.ExitError
+		if printARN && errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		fail(code, fmt.Sprintf("Error: %v", err))
+	}
+	if export {
+		fmt.Println(exportLine(profile, region))
+	}
+}
+
+// mutatingFlags are the flags that write files or AWS CLI settings, which
+// read-only mode rejects.
+var mutatingFlags = map[string]bool{
+	"pin":              true,
+	"unpin":            true,
+	"touch":            true,
+	"rename":           true,
+	"mirror":           true,
+	"new":              true,
+	"merge-config":     true,
+	"audit-log":        true,
+	"refresh-cache":    true,
+	"configure-region": true,
+	"region-only":      true,
+}
+
+// hiddenFlags are maintainer-facing flags left out of the usage message.
+var hiddenFlags = map[string]bool{
+	"benchmark-parse": true,
+}
+
+// usage prints the same message as flag.PrintDefaults without the hidden
+// flags.
+func usage() {
+	output := flag.CommandLine.Output()
+	fmt.Fprintf(output, "Usage of %s:\n", os.Args[0])
+	flag.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		line := "  -" + f.Name
+		if name != "" {
+			line += " " + name
+		}
+		fmt.Fprintf(output, "%s\n    \t%s\n", line, strings.ReplaceAll(usage, "\n", "\n    \t"))
+	})
+}
+
+// benchmarkParse calls parse n times and returns the total time taken.
+func benchmarkParse(n int, parse func() error) (time.Duration, error) {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if err := parse(); err != nil {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+func filterByAccount(profiles map[string]AWSProfile, accountID string) map[string]AWSProfile {
+	matches := make(map[string]AWSProfile)
+	for name, profile := range profiles {
+		if profile.AWSAccountID == accountID {
+			matches[name] = profile
+		}
+	}
+	return matches
+}
+
+// topScoreTies returns the names of the profiles sharing the top score, given
+// profiles ranked best first and their scores.
+func topScoreTies(ranked []AWSProfile, scores []int) []string {
+	var tied []string
+	for i, profile := range ranked {
+		if scores[i] != scores[0] {
+			break
+		}
+		tied = append(tied, profile.Name)
+	}
+	return tied
+}
+
+// handleProfileSearch offers the best search result, returning its name if
+// the user accepts it. With -no-prompt there is no one to ask, so it fails
+// with errNoPrompt.
+func handleProfileSearch(searchResults []AWSProfile) (string, error) {
+	if len(searchResults) > 0 {
+		suggestedProfile := searchResults[0]
+		if cfg.NoPrompt {
+			return "", errNoPrompt
+		}
+		fmt.Fprintf(infoOutput, "Use suggested profile \"%s\"? (y/n): ", suggestedProfile.Name)
+		var response string
+		fmt.Scanln(&response)
+		if strings.ToLower(response) == "y" {
+			return suggestedProfile.Name, nil
+		}
+	} else {
+		fmt.Fprintln(infoOutput, "No matching profiles found.")
+	}
+	return "", nil
+}
+
+// homeDir returns the directory holding the credentials and state files:
+// $AWS_PROFILE_SELECTOR_HOME if it is set, otherwise the user's home
+// directory. main checks with resolveHomeDir that there is one first, so
+// paths are never silently relative to the current directory.
+func homeDir() string {
+	dir, _ := resolveHomeDir()
+	return dir
+}
+
+// resolveHomeDir is homeDir, failing when neither
+// $AWS_PROFILE_SELECTOR_HOME nor the user's home directory is set.
+func resolveHomeDir() (string, error) {
+	if dir := os.Getenv("AWS_PROFILE_SELECTOR_HOME"); dir != "" {
+		return dir, nil
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil || dir == "" {
+		return "", errors.New("no home directory: set $HOME, or $AWS_PROFILE_SELECTOR_HOME to the directory holding .aws/credentials")
+	}
+	return dir, nil
+}
+
+func credentialsFilePath() string {
+	return filepath.Join(homeDir(), ".aws", "credentials")
+}
+
+// awsConfigFilePath returns the path of the AWS CLI's config file.
+func awsConfigFilePath() string {
+	return filepath.Join(homeDir(), ".aws", "config")
+}
+
+// Another process may be rewriting the credentials file while it is read, in
+// which case it can briefly look empty. A non-empty file that yields no
+// profiles is re-read a few times before giving up.
+const (
+	loadRetries    = 2
+	loadRetryDelay = 100 * time.Millisecond
+)
+
+func loadProfiles(opts parseOptions) (map[string]AWSProfile, error) {
+	credentialsPath := credentialsFilePath()
+	var parser *credentialsParser
+	for attempt := 0; ; attempt++ {
+		parser = newCredentialsParser(opts)
+		if err := readCredentialsFile(credentialsPath, parser, map[string]bool{}); err != nil {
+			return nil, err
+		}
+		if len(parser.profiles) > 0 || attempt == loadRetries || !fileHasContent(credentialsPath) {
+			break
+		}
+		time.Sleep(loadRetryDelay)
+	}
+	return parsedProfiles(parser), nil
+}
+
+// loadProfilesFromReader parses credentials file content read from r, such
+// as stdin. Relative include paths are resolved against the working
+// directory.
+func loadProfilesFromReader(r io.Reader, opts parseOptions) (map[string]AWSProfile, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	parser := newCredentialsParser(opts)
+	if err := readCredentials(r, dir, parser, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return parsedProfiles(parser), nil
+}
+
+// parsedProfiles returns the allowed profiles parser found, warning about
+// any defined more than once and any unset variables they reference.
+func parsedProfiles(parser *credentialsParser) map[string]AWSProfile {
+	for _, name := range parser.duplicates {
+		warn("profile %s is defined more than once; only the last definition is used", name)
+	}
+	for _, name := range parser.unsetVars {
+		warn("environment variable %s is not set; it was expanded to an empty string", name)
+	}
+	return filterAllowedProfiles(parser.profiles, cfg.Allow, cfg.Deny)
+}
+
+// awsCLIProfileKeys are the settings read for each profile discovered with
+// -use-aws-cli.
+var awsCLIProfileKeys = []string{
+	"aws_account_id",
+	"aws_access_key_id",
+	"aws_secret_access_key",
+	"region",
+	"role_arn",
+	"source_profile",
+	"sso_start_url",
+	"sso_session",
+	"sso_account_id",
+	"sso_role_name",
+	"credential_process",
+}
+
+// loadProfilesFromAWSCLI discovers profiles with `aws configure
+// list-profiles` and reads their settings with `aws configure get`, so the
+// list matches the CLI's own view of its configuration.
+func loadProfilesFromAWSCLI() (map[string]AWSProfile, error) {
+	output, err := exec.Command(cfg.AWSCLIPath, "configure", "list-profiles").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing profiles with the AWS CLI: %v", err)
+	}
+
+	parser := newCredentialsParser(parseOptions{})
+	for _, name := range strings.Fields(string(output)) {
+		parser.parseLine("[" + name + "]")
+		for _, key := range awsCLIProfileKeys {
+			// aws configure get fails when the key isn't set.
+			output, err := exec.Command(cfg.AWSCLIPath, "configure", "get", key, "--profile", name).Output()
+			if err != nil {
+				continue
+			}
+			value := strings.TrimSpace(string(output))
+			if key == "aws_secret_access_key" {
+				// Only whether there is a secret matters, e.g. to
+				// isCompleteProfile; its value is never kept.
+				value = redactValue(value)
+			}
+			parser.parseLine(key + " = " + value)
+		}
+	}
+	return filterAllowedProfiles(parser.profiles, cfg.Allow, cfg.Deny), nil
+}
+
+func fileHasContent(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Size() > 0
+}
+
+func splitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if match, _ := path.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAllowedProfiles drops profiles matching a deny pattern and, when
+// allow patterns are given, profiles matching none of them. Deny wins when a
+// profile matches both.
+func filterAllowedProfiles(profiles map[string]AWSProfile, allow, deny []string) map[string]AWSProfile {
+	if len(allow) == 0 && len(deny) == 0 {
+		return profiles
+	}
+	filtered := make(map[string]AWSProfile)
+	for name, profile := range profiles {
+		if matchesAnyPattern(name, deny) {
+			continue
+		}
+		if len(allow) > 0 && !matchesAnyPattern(name, allow) {
+			continue
+		}
+		filtered[name] = profile
+	}
+	return filtered
+}
+
+// readCredentialsFile streams path line by line into parser, reading any
+// files referenced by "; include <file>" directives in place. Relative
+// include paths are resolved against the directory of the including file.
+// visiting holds the files currently being read so circular includes are
+// reported instead of recursing forever.
+func readCredentialsFile(path string, parser *credentialsParser, visiting map[string]bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if visiting[absPath] {
+		return fmt.Errorf("circular include of %s", absPath)
+	}
+	visiting[absPath] = true
+	defer delete(visiting, absPath)
+
+	file, err := os.Open(absPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return readCredentials(file, filepath.Dir(absPath), parser, visiting)
+}
+
+// readCredentials streams r line by line into parser like
+// readCredentialsFile, resolving relative include paths against dir.
+func readCredentials(r io.Reader, dir string, parser *credentialsParser, visiting map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if includePath, ok := parseIncludeDirective(line); ok {
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(dir, includePath)
+			}
+			if err := readCredentialsFile(includePath, parser, visiting); err != nil {
+				return err
+			}
+			continue
+		}
+		parser.parseLine(line)
+	}
+
+	return scanner.Err()
+}
+
+// parseIncludeDirective returns the file named by a "; include <file>"
+// line. Only the ";" comment form is a directive, so an ordinary "#"
+// comment that happens to read "include something" stays a comment.
+func parseIncludeDirective(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, ";") {
+		return "", false
+	}
+	fields := strings.Fields(line[1:])
+	if len(fields) != 2 || fields[0] != "include" {
+		return "", false
+	}
+	return fields[1], true
+}
+
+// Environment classes a profile can belong to, see profileEnvironment.
+const (
+	envProd  = "prod"
+	envTest  = "test"
+	envOther = "other"
+)
+
+// profileEnvironment classifies a profile by its name.
+func profileEnvironment(profileName string) string {
+	if strings.Contains(profileName, "prod") {
+		return envProd
+	}
+	if strings.Contains(profileName, "test") {
+		return envTest
+	}
+	return envOther
+}
+
+// profileIcon returns the marker shown before a profile in the prompt: its
+// configured icon, or else the one for its environment.
+func profileIcon(profileName string) string {
+	if icon := cfg.Profiles[profileName].Icon; icon != "" {
+		return icon
+	}
+	return getProfileEmoji(profileName)
+}
+
+// profileStyle returns the style of a profile's name in the prompt, in its
+// configured color if it has one.
+func profileStyle(profileName string) lipgloss.Style {
+	style := lipgloss.NewStyle()
+	if color := cfg.Profiles[profileName].Color; color != "" {
+		style = style.Foreground(lipgloss.Color(color))
+	}
+	return style
+}
+
+// asciiTerminal reports whether the terminal described by getenv probably
+// can't show anything but ASCII: TERM is "dumb", or the locale is set and
+// isn't UTF-8.
+func asciiTerminal(getenv func(string) string) bool {
+	if getenv("TERM") == "dumb" {
+		return true
+	}
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := strings.ToLower(getenv(name)); locale != "" {
+			return !strings.Contains(locale, "utf-8") && !strings.Contains(locale, "utf8")
+		}
+	}
+	return false
+}
+
+// Markers shown in the prompt with -ascii.
+const (
+	asciiProd       = "[P]"
+	asciiTest       = "[T]"
+	asciiOther      = "[ ]"
+	asciiPinned     = "[*]"
+	asciiIncomplete = "!"
+)
+
+func getProfileEmoji(profileName string) string {
+	if cfg.ASCII {
+		switch profileEnvironment(profileName) {
+		case envProd:
+			return asciiProd
+		case envTest:
+			return asciiTest
+		}
+		return asciiOther
+	}
+	switch profileEnvironment(profileName) {
+	case envProd:
+		return "" // 🔴
+	case envTest:
+		return "" // 🟡
+	}
+	return "" // 🟢
+}
+
+var profileNameRegexp = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_-]*$")
+
+func isValidProfileName(name string) bool {
+	return profileNameRegexp.MatchString(name)
+}
+
+// parseOptions control how credentials files are parsed.
+type parseOptions struct {
+	// RawValues keeps values exactly as written after the "=" and the
+	// spaces following it, instead of trimming trailing whitespace.
+	RawValues bool
+	// ExpandEnv replaces $VAR and ${VAR} in values with the variable from
+	// the environment.
+	ExpandEnv bool
+}
+
+func parseAWSCredentials(content string, opts parseOptions) map[string]AWSProfile {
+	parser := newCredentialsParser(opts)
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		parser.parseLine(scanner.Text())
+	}
+	return parser.profiles
+}
+
+// credentialsParser accumulates profiles one line at a time so that files
+// can be parsed while they are being read.
+type credentialsParser struct {
+	opts           parseOptions
+	profiles       map[string]AWSProfile
+	currentProfile string
+	// comments holds the comment lines seen since the last non-comment line,
+	// which become the description of a profile whose header follows them.
+	comments []string
+	// inKey is set once a key has been seen in the current section, after
+	// which indented lines are continuations of that key.
+	inKey bool
+	// duplicates holds the names of sections defined more than once, in the
+	// order their repeats were seen.
+	duplicates []string
+	// unsetVars holds the environment variables referenced by values but
+	// not set, in the order they were first seen, with opts.ExpandEnv.
+	unsetVars []string
+}
+
+func newCredentialsParser(opts parseOptions) *credentialsParser {
+	return &credentialsParser{opts: opts, profiles: make(map[string]AWSProfile)}
+}
+
+func (p *credentialsParser) parseLine(line string) {
+	// Files saved by some Windows editors start with a byte order mark,
+	// which would otherwise hide the first section header.
+	line = strings.TrimPrefix(line, "\uFEFF")
+	line = strings.ToValidUTF8(line, "\uFFFD")
+	raw := line
+	indented := strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+	line = strings.TrimSpace(line)
+	if line == "" {
+		p.comments = nil
+		return
+	}
+	isSection := strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]")
+	if indented && p.inKey && !isSection {
+		// Nested settings such as those under "s3 =" belong to the parent
+		// key; none of them are used here.
+		return
+	}
+	if strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+		p.comments = append(p.comments, strings.TrimSpace(line[1:]))
+		return
+	}
+	comments := p.comments
+	p.comments = nil
+
+	if isSection {
+		p.inKey = false
+		profileName := line[1 : len(line)-1]
+		if isValidProfileName(profileName) && profileName != "default" {
+			if _, ok := p.profiles[profileName]; ok {
+				p.duplicates = append(p.duplicates, profileName)
+			}
+			p.currentProfile = profileName
+			p.profiles[p.currentProfile] = AWSProfile{
+				Name:        p.currentProfile,
+				Description: strings.Join(comments, " "),
+			}
+		} else {
+			p.currentProfile = ""
+		}
+	} else if strings.Contains(line, "=") {
+		p.inKey = true
+		if p.currentProfile == "" {
+			return
+		}
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if p.opts.RawValues {
+			_, value, _ = strings.Cut(raw, "=")
+			value = strings.TrimLeft(value, " \t")
+		}
+		if p.opts.ExpandEnv {
+			value = os.Expand(value, p.lookupEnv)
+		}
+		profile := p.profiles[p.currentProfile]
+		switch key {
+		case "aws_access_key_id":
+			profile.AWSAccessKeyID = value
+		case "aws_secret_access_key":
+			profile.AWSSecretAccessKey = value
+		case "aws_account_id":
+			profile.AWSAccountID = value
+		case "region":
+			profile.Region = value
+		case "role_arn":
+			profile.RoleARN = value
+		case "source_profile":
+			profile.SourceProfile = value
+		case "sso_start_url":
+			profile.SSOStartURL = value
+		case "sso_session":
+			profile.SSOSession = value
+		case "sso_account_id":
+			profile.SSOAccountID = value
+		case "sso_role_name":
+			profile.SSORoleName = value
+		case "credential_process":
+			profile.CredentialProcess = value
+		}
+		p.profiles[p.currentProfile] = profile
+	}
+}
+
+// lookupEnv returns the value of the environment variable name for
+// os.Expand, noting it if it isn't set.
+func (p *credentialsParser) lookupEnv(name string) string {
+	value, ok := os.LookupEnv(name)
+	if !ok && !slices.Contains(p.unsetVars, name) {
+		p.unsetVars = append(p.unsetVars, name)
+	}
+	return value
+}
+
+func getLastUsedProfile() string {
+	content, err := os.ReadFile(filepath.Join(homeDir(), lastUsedFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func saveLastUsedProfile(profileName string) error {
+	return os.WriteFile(filepath.Join(homeDir(), lastUsedFile), []byte(profileName), 0644)
+}
+
+// historyEntry records when a profile was last used.
+type historyEntry struct {
+	Name   string
+	UsedAt time.Time
+}
+
+// getProfileHistory returns the most recently used profiles, most recent
+// first. Each line of the history file holds a profile name and the Unix
+// time it was used.
+func getProfileHistory() []historyEntry {
+	content, err := os.ReadFile(filepath.Join(homeDir(), historyFile))
+	if err != nil {
+		return nil
+	}
+
+	var history []historyEntry
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		entry := historyEntry{Name: fields[0]}
+		if len(fields) > 1 {
+			if seconds, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				entry.UsedAt = time.Unix(seconds, 0)
+			}
+		}
+		history = append(history, entry)
+	}
+	return history
+}
+
+func saveProfileHistory(history []historyEntry) error {
+	var content strings.Builder
+	for _, entry := range history {
+		fmt.Fprintf(&content, "%s %d\n", entry.Name, entry.UsedAt.Unix())
+	}
+	return os.WriteFile(filepath.Join(homeDir(), historyFile), []byte(content.String()), 0644)
+}
+
+// pushProfileHistory moves profileName to the front of history with usedAt
+// as its timestamp, dropping older entries past maxHistory.
+func pushProfileHistory(history []historyEntry, profileName string, usedAt time.Time) []historyEntry {
+	result := []historyEntry{{Name: profileName, UsedAt: usedAt}}
+	for _, entry := range history {
+		if entry.Name != profileName && len(result) < maxHistory {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// recordProfileUse remembers profileName as the last used profile and adds
+// it to the history.
+func recordProfileUse(profileName string) error {
+	return withStateLock(func() error {
+		if err := saveLastUsedProfile(profileName); err != nil {
+			return err
+		}
+		return saveProfileHistory(pushProfileHistory(getProfileHistory(), profileName, time.Now()))
+	})
+}
+
+// renameProfileState moves what the state files remember about oldName,
+// such as its pin, history and remembered region, to newName. Files that
+// don't mention oldName are left alone.
+func renameProfileState(oldName, newName string) error {
+	return withStateLock(func() error {
+		if getLastUsedProfile() == oldName {
+			if err := saveLastUsedProfile(newName); err != nil {
+				return err
+			}
+		}
+
+		if pinned := getPinnedProfiles(); slices.Contains(pinned, oldName) {
+			for i, name := range pinned {
+				if name == oldName {
+					pinned[i] = newName
+				}
+			}
+			if err := savePinnedProfiles(pinned); err != nil {
+				return err
+			}
+		}
+
+		history := getProfileHistory()
+		renamed := false
+		for i, entry := range history {
+			if entry.Name == oldName {
+				history[i].Name = newName
+				renamed = true
+			}
+		}
+		if renamed {
+			if err := saveProfileHistory(history); err != nil {
+				return err
+			}
+		}
+
+		if regions := getRememberedRegions(); regions[oldName] != "" {
+			regions[newName] = regions[oldName]
+			delete(regions, oldName)
+			if err := saveRememberedRegions(regions); err != nil {
+				return err
+			}
+		}
+
+		if counts := getUsageCounts(); counts[oldName] > 0 {
+			counts[newName] += counts[oldName]
+			delete(counts, oldName)
+			if err := saveUsageCounts(counts); err != nil {
+				return err
+			}
+		}
+
+		if verifications := loadVerifications(); !verifications[oldName].Time.IsZero() {
+			verifications[newName] = verifications[oldName]
+			delete(verifications, oldName)
+			if err := saveVerifications(verifications); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lastNProfiles returns up to n of the most recently used profiles.
+func lastNProfiles(history []historyEntry, n int) []historyEntry {
+	if n < len(history) {
+		return history[:n]
+	}
+	return history
+}
+
+// getRememberedRegions returns the regions chosen with -region-only, keyed
+// by profile name.
+func getRememberedRegions() map[string]string {
+	regions := make(map[string]string)
+	content, err := os.ReadFile(filepath.Join(homeDir(), regionsFile))
+	if err != nil {
+		return regions
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			regions[fields[0]] = fields[1]
+		}
+	}
+	return regions
+}
+
+func saveRememberedRegions(regions map[string]string) error {
+	var names []string
+	for name := range regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&content, "%s %s\n", name, regions[name])
+	}
+	return os.WriteFile(filepath.Join(homeDir(), regionsFile), []byte(content.String()), 0644)
+}
+
+// changeProfileRegion remembers a new region for profileName, taken from
+// -region or picked interactively, and returns it.
+func changeProfileRegion(profileName string) (string, error) {
+	region := regionFlag
+	if region == "" {
+		var err error
+		region, err = showRegionSelectionPrompt(profileName)
+		if err != nil {
+			return "", err
+		}
+	}
+	region, ok := validateRegion(region)
+	if !ok {
+		return "", fmt.Errorf("unrecognized region %q", region)
+	}
+
+	err := withStateLock(func() error {
+		regions := getRememberedRegions()
+		regions[profileName] = region
+		return saveRememberedRegions(regions)
+	})
+	return region, err
+}
+
+func showRegionSelectionPrompt(profileName string) (string, error) {
+	var query string
+	region := getRememberedRegions()[profileName]
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Select a region for "+profileName).
+				Placeholder("type a code or a place, e.g. ireland").
+				Value(&query),
+			huh.NewSelect[string]().
+				OptionsFunc(func() []huh.Option[string] {
+					var options []huh.Option[string]
+					for _, code := range searchRegions(query) {
+						options = append(options, huh.NewOption(fmt.Sprintf("%-14s  %s", code, regionNames[code]), code))
+					}
+					return options
+				}, &query).
+				Height(menuHeight()).
+				Value(&region),
+		),
+	).WithKeyMap(selectKeyMap()).WithOutput(infoOutput)
+	if err := runForm(form); err != nil {
+		return "", err
+	}
+	return region, nil
+}
+
+// Where activeProfileSource found the active profile.
+const (
+	profileSourceEnv   = "env"
+	profileSourceState = "state"
+	profileSourceNone  = "none"
+)
+
+// activeProfile returns the profile in use: AWS_PROFILE if it is set,
+// otherwise the last used profile.
+func activeProfile() string {
+	name, _ := activeProfileSource()
+	return name
+}
+
+// activeProfileSource returns activeProfile and where it came from.
+func activeProfileSource() (string, string) {
+	if name := os.Getenv("AWS_PROFILE"); name != "" {
+		return name, profileSourceEnv
+	}
+	if name := getLastUsedProfile(); name != "" {
+		return name, profileSourceState
+	}
+	return "", profileSourceNone
+}
+
+func cacheFilePath() string {
+	return filepath.Join(homeDir(), cacheFile)
+}
+
+// saveCachedProfiles writes profiles to the cache file with their
+// credentials redacted, so prompt integrations can read them without
+// re-parsing.
+func saveCachedProfiles(profiles map[string]AWSProfile) error {
+	var cached []AWSProfile
+	for _, profile := range filterProfiles(profiles, "") {
+		profile.AWSAccessKeyID = redactValue(profile.AWSAccessKeyID)
+		profile.AWSSecretAccessKey = redactValue(profile.AWSSecretAccessKey)
+		cached = append(cached, profile)
+	}
+	content, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cacheFilePath(), content, 0644)
+}
+
+func loadCachedProfiles() (map[string]AWSProfile, error) {
+	content, err := os.ReadFile(cacheFilePath())
+	if err != nil {
+		return nil, err
+	}
+	var cached []AWSProfile
+	if err := json.Unmarshal(content, &cached); err != nil {
+		return nil, fmt.Errorf("error parsing profile cache: %v", err)
+	}
+	profiles := make(map[string]AWSProfile)
+	for _, profile := range cached {
+		profiles[profile.Name] = profile
+	}
+	return profiles, nil
+}
+
+// diffProfiles renders a field-by-field comparison of two profiles, marking
+// differing fields with "*". Credentials are compared but never printed.
+func diffProfiles(a, b AWSProfile) string {
+	type field struct {
+		name   string
+		a, b   string
+		secret bool
+	}
+	fields := []field{
+		{"aws_account_id", a.AWSAccountID, b.AWSAccountID, false},
+		{"region", a.Region, b.Region, false},
+		{"role_arn", a.RoleARN, b.RoleARN, false},
+		{"source_profile", a.SourceProfile, b.SourceProfile, false},
+		{"credential_process", a.CredentialProcess, b.CredentialProcess, false},
+		{"aws_access_key_id", a.AWSAccessKeyID, b.AWSAccessKeyID, true},
+		{"aws_secret_access_key", a.AWSSecretAccessKey, b.AWSSecretAccessKey, true},
+	}
+
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\tfield\t%s\t%s\n", a.Name, b.Name)
+	for _, f := range fields {
+		marker := ""
+		if f.a != f.b {
+			marker = "*"
+		}
+		valueA, valueB := displayValue(f.a), displayValue(f.b)
+		if f.secret {
+			valueA, valueB = displayValue(redactValue(f.a)), displayValue(redactValue(f.b))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", marker, f.name, valueA, valueB)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+func displayValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// redactedValue replaces secrets in output and in the profile cache.
+const redactedValue = "<redacted>"
+
+func redactValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// findDuplicateAccessKeys returns the names of profiles that share an
+// aws_access_key_id, one sorted group per shared key.
+func findDuplicateAccessKeys(profiles map[string]AWSProfile) [][]string {
+	byKey := make(map[string][]string)
+	for _, profile := range filterProfiles(profiles, "") {
+		key := profile.AWSAccessKeyID
+		if key != "" && key != redactedValue {
+			byKey[key] = append(byKey[key], profile.Name)
+		}
+	}
+
+	var duplicates [][]string
+	for _, names := range byKey {
+		if len(names) > 1 {
+			duplicates = append(duplicates, names)
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i][0] < duplicates[j][0]
+	})
+	return duplicates
+}
+
+// findDanglingSourceProfiles returns the sorted names of profiles whose
+// source_profile names a profile that isn't defined.
+func findDanglingSourceProfiles(profiles map[string]AWSProfile) []string {
+	var dangling []string
+	for _, profile := range filterProfiles(profiles, "") {
+		source := profile.SourceProfile
+		if source == "" || source == "default" {
+			continue
+		}
+		if _, ok := profiles[source]; !ok {
+			dangling = append(dangling, profile.Name)
 		}
 	}
+	return dangling
+}
 
-	if selectedProfile == "" {
-		fmt.Println("No profile selected. Exiting.")
-		os.Exit(1)
+func getPinnedProfiles() []string {
+	content, err := os.ReadFile(filepath.Join(homeDir(), pinnedFile))
+	if err != nil {
+		return nil
 	}
+	return strings.Fields(string(content))
+}
 
-	if err := selectAndUseProfile(selectedProfile); err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+func savePinnedProfiles(names []string) error {
+	content := strings.Join(names, "\n")
+	if content != "" {
+		content += "\n"
 	}
+	return os.WriteFile(filepath.Join(homeDir(), pinnedFile), []byte(content), 0644)
 }
 
-func handleProfileSearch(profiles map[string]AWSProfile, searchTerm string) string {
-	searchResults := searchProfiles(profiles, searchTerm)
-	if len(searchResults) > 0 {
-		suggestedProfile := searchResults[0]
-		fmt.Printf("Use suggested profile \"%s\"? (y/n): ", suggestedProfile.Name)
-		var response string
-		fmt.Scanln(&response)
-		if strings.ToLower(response) == "y" {
-			return suggestedProfile.Name
+func pinProfile(pinned []string, name string) []string {
+	for _, p := range pinned {
+		if p == name {
+			return pinned
 		}
-	} else {
-		fmt.Println("No matching profiles found.")
 	}
-	return ""
+	return append(pinned, name)
 }
 
-func loadProfiles() (map[string]AWSProfile, error) {
-	homeDir, _ := os.UserHomeDir()
-	credentialsPath := filepath.Join(homeDir, ".aws", "credentials")
-	content, err := os.ReadFile(credentialsPath)
-	if err != nil {
-		return nil, err
+func unpinProfile(pinned []string, name string) []string {
+	var result []string
+	for _, p := range pinned {
+		if p != name {
+			result = append(result, p)
+		}
 	}
-	return parseAWSCredentials(string(content)), nil
+	return result
 }
 
-func getProfileEmoji(profileName string) string {
-	if strings.Contains(profileName, "prod") {
-		return "" // 🔴
+func updatePinnedProfiles(profiles map[string]AWSProfile, pinName, unpinName string) error {
+	if pinName != "" {
+		if _, ok := profiles[pinName]; !ok {
+			return fmt.Errorf("profile %q not found", pinName)
+		}
 	}
-	if strings.Contains(profileName, "test") {
-		return "" // 🟡
+
+	err := withStateLock(func() error {
+		pinned := getPinnedProfiles()
+		if pinName != "" {
+			pinned = pinProfile(pinned, pinName)
+		}
+		if unpinName != "" {
+			pinned = unpinProfile(pinned, unpinName)
+		}
+		return savePinnedProfiles(pinned)
+	})
+	if err != nil {
+		return err
 	}
-	return "" // 🟢
+
+	if pinName != "" {
+		logInfo("Pinned profile: %s\n", pinName)
+	}
+	if unpinName != "" {
+		logInfo("Unpinned profile: %s\n", unpinName)
+	}
+	return nil
 }
 
-func isValidProfileName(name string) bool {
-	match, _ := regexp.MatchString("^[a-zA-Z0-9][a-zA-Z0-9_-]*$", name)
-	return match
+// orderProdLast moves prod profiles after all others, keeping the order
+// within each group, so the safer choices are the easier ones to pick.
+func orderProdLast(profiles []AWSProfile) []AWSProfile {
+	result := append([]AWSProfile(nil), profiles...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return profileEnvironment(result[i].Name) != envProd && profileEnvironment(result[j].Name) == envProd
+	})
+	return result
 }
 
-func parseAWSCredentials(content string) map[string]AWSProfile {
-	profiles := make(map[string]AWSProfile)
-	var currentProfile string
+// orderPinnedFirst moves the pinned profiles, in pin order, ahead of the
+// rest of the list while keeping the relative order of everything else.
+func orderPinnedFirst(profiles []AWSProfile, pinned []string) []AWSProfile {
+	byName := make(map[string]AWSProfile)
+	for _, profile := range profiles {
+		byName[profile.Name] = profile
+	}
 
-	scanner := bufio.NewScanner(strings.NewReader(content))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
-			profileName := line[1 : len(line)-1]
-			if isValidProfileName(profileName) && profileName != "default" {
-				currentProfile = profileName
-				profiles[currentProfile] = AWSProfile{Name: currentProfile}
-			} else {
-				currentProfile = ""
-			}
-		} else if currentProfile != "" && strings.Contains(line, "=") {
-			parts := strings.SplitN(line, "=", 2)
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			profile := profiles[currentProfile]
-			switch key {
-			case "aws_access_key_id":
-				profile.AWSAccessKeyID = value
-			case "aws_secret_access_key":
-				profile.AWSSecretAccessKey = value
-			case "aws_account_id":
-				profile.AWSAccountID = value
-			case "region":
-				profile.Region = value
-			case "role_arn":
-				profile.RoleARN = value
-			case "source_profile":
-				profile.SourceProfile = value
-			}
-			profiles[currentProfile] = profile
+	var result []AWSProfile
+	isPinned := make(map[string]bool)
+	for _, name := range pinned {
+		if profile, ok := byName[name]; ok && !isPinned[name] {
+			result = append(result, profile)
+			isPinned[name] = true
 		}
 	}
-
-	return profiles
+	for _, profile := range profiles {
+		if !isPinned[profile.Name] {
+			result = append(result, profile)
+		}
+	}
+	return result
 }
 
-func getLastUsedProfile() string {
-	homeDir, _ := os.UserHomeDir()
-	content, err := os.ReadFile(filepath.Join(homeDir, lastUsedFile))
+// getCurrentRegion returns the region the AWS CLI is configured with for
+// the profile, or "" if there is none.
+func getCurrentRegion(profileName string) string {
+	cmd := exec.Command(cfg.AWSCLIPath, "configure", "get", "region")
+	cmd.Env = append(os.Environ(), "AWS_PROFILE="+profileName)
+	output, err := cmd.Output()
 	if err != nil {
 		return ""
 	}
-	return strings.TrimSpace(string(content))
-}
-
-func saveLastUsedProfile(profileName string) error {
-	homeDir, _ := os.UserHomeDir()
-	return os.WriteFile(filepath.Join(homeDir, lastUsedFile), []byte(profileName), 0644)
+	return strings.TrimSpace(string(output))
 }
 
-func getCurrentRegion() string {
-	cmd := exec.Command("aws", "configure", "get", "region")
-	output, err := cmd.Output()
-	if err != nil {
+func regionLabel(region string) string {
+	if region == "" {
 		return "Not set"
 	}
-	return strings.TrimSpace(string(output))
+	return region
+}
+
+// rankWeights controls how much each kind of match contributes to a
+// profile's search score. Each weight is applied once per matching term.
+type rankWeights struct {
+	Substring   int
+	Prefix      int
+	Subsequence int
+	AccountID   int
+	Region      int
 }
 
+// Name matches outweigh account id and region matches so that a term found
+// in both places still prefers the profile it names.
+var defaultRankWeights = rankWeights{Substring: 2, AccountID: 1, Region: 1}
+
 func searchProfiles(profiles map[string]AWSProfile, query string) []AWSProfile {
 	query = strings.ToLower(query)
+	weights := cfg.Weights
+	matchAll := cfg.MatchAllTerms
 	var rankedProfiles []AWSProfile
 
 	type profileScore struct {
@@ -188,16 +1920,20 @@ func searchProfiles(profiles map[string]AWSProfile, query string) []AWSProfile {
 
 	var scores []profileScore
 
-	for name, profile := range profiles {
-		score := rankProfile(name, query)
+	for _, profile := range profiles {
+		score := rankProfile(profile, query, weights, matchAll)
 		if score > 0 {
 			scores = append(scores, profileScore{profile: profile, score: score})
 		}
 	}
 
-	// Sort profiles by score in descending order
+	// Sort profiles by score in descending order, then by name so that
+	// equal scores always come back in the same order.
 	sort.Slice(scores, func(i, j int) bool {
-		return scores[i].score > scores[j].score
+		if scores[i].score != scores[j].score {
+			return scores[i].score > scores[j].score
+		}
+		return scores[i].profile.Name < scores[j].profile.Name
 	})
 
 	for _, ps := range scores {
@@ -207,22 +1943,65 @@ func searchProfiles(profiles map[string]AWSProfile, query string) []AWSProfile {
 	return rankedProfiles
 }
 
-func rankProfile(profileName, query string) int {
-	profileName = strings.ToLower(profileName)
+// rankProfile sums the scores of each term of query against profile. With
+// matchAll, a profile scores 0 unless every term scores something, so a
+// query only suggests profiles that match all of its terms.
+func rankProfile(profile AWSProfile, query string, weights rankWeights, matchAll bool) int {
+	profileName := strings.ToLower(profile.Name)
 	terms := strings.Fields(query)
 	score := 0
 
 	for _, term := range terms {
-		if strings.Contains(profileName, term) {
-			score += 1
+		termScore := nameScore(profileName, term, weights)
+		if profile.AWSAccountID != "" && strings.Contains(profile.AWSAccountID, term) {
+			termScore += weights.AccountID
+		}
+		if profile.Region != "" && strings.Contains(strings.ToLower(profile.Region), term) {
+			termScore += weights.Region
+		}
+		if matchAll && termScore <= 0 {
+			return 0
 		}
+		score += termScore
 	}
 
 	return score
 }
 
-func showProfileSelectionPrompt(profiles map[string]AWSProfile) (string, error) {
-	var options []huh.Option[string]
+// nameScore scores how well term matches name using the substring, prefix
+// and subsequence weights.
+func nameScore(name, term string, weights rankWeights) int {
+	if strings.Contains(name, term) {
+		if strings.HasPrefix(name, term) {
+			return weights.Substring + weights.Prefix
+		}
+		return weights.Substring
+	}
+	if isSubsequence(term, name) {
+		return weights.Subsequence
+	}
+	return 0
+}
+
+// isSubsequence reports whether the characters of term appear in s in order,
+// not necessarily adjacent.
+func isSubsequence(term, s string) bool {
+	remaining := []rune(term)
+	for _, r := range s {
+		if len(remaining) > 0 && r == remaining[0] {
+			remaining = remaining[1:]
+		}
+	}
+	return len(remaining) == 0
+}
+
+// filterProfiles returns the candidates for an interactive search query:
+// every profile sorted by name when the query is empty, otherwise the
+// profiles ranked by searchProfiles.
+func filterProfiles(profiles map[string]AWSProfile, query string) []AWSProfile {
+	if strings.TrimSpace(query) != "" {
+		return searchProfiles(profiles, query)
+	}
 
 	var names []string
 	for name := range profiles {
@@ -230,58 +2009,622 @@ func showProfileSelectionPrompt(profiles map[string]AWSProfile) (string, error)
 	}
 	sort.Strings(names)
 
+	var result []AWSProfile
 	for _, name := range names {
-		profile := profiles[name]
-		emoji := getProfileEmoji(name)
-		displayName := fmt.Sprintf("%s %s (%s)", emoji, name, profile.AWSAccountID)
-		options = append(options, huh.NewOption(displayName, name))
+		result = append(result, profiles[name])
+	}
+	return result
+}
+
+// profileLabel returns the name shown for a profile, without the -prefix
+// when -strip-prefix is set.
+func profileLabel(name string) string {
+	if cfg.StripPrefix && cfg.Prefix != "" && name != cfg.Prefix {
+		return strings.TrimPrefix(name, cfg.Prefix)
+	}
+	return name
+}
+
+func filterByPrefix(profiles map[string]AWSProfile, prefix string) map[string]AWSProfile {
+	if prefix == "" {
+		return profiles
+	}
+	matches := make(map[string]AWSProfile)
+	for name, profile := range profiles {
+		if strings.HasPrefix(name, prefix) {
+			matches[name] = profile
+		}
+	}
+	return matches
+}
+
+const (
+	// optionChromeWidth is the number of columns the select prompt draws
+	// before each option: the border and the cursor.
+	optionChromeWidth = 4
+	// minElidedNameLength is the shortest elideName shortens a profile
+	// name to, however narrow the terminal.
+	minElidedNameLength = 12
+)
+
+// elideName shortens name to max characters by replacing its middle with
+// an ellipsis, keeping the start and the end, which tend to tell generated
+// profile names apart.
+func elideName(name string, max int) string {
+	runes := []rune(name)
+	if len(runes) <= max {
+		return name
+	}
+	if max < 1 {
+		return ""
+	}
+	keep := max - 1
+	head := (keep + 1) / 2
+	return string(runes[:head]) + "…" + string(runes[len(runes)-(keep-head):])
+}
+
+// terminalWidth returns the width of the terminal prompts are drawn on, or
+// 0 if it isn't a terminal.
+func terminalWidth() int {
+	width, _, err := term.GetSize(infoOutput.Fd())
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+func profileOption(profile AWSProfile, pinned bool) huh.Option[string] {
+	emoji := profileIcon(profile.Name)
+	if pinned {
+		// The environment marker stays, so a pinned prod profile still
+		// looks like one.
+		star := "★"
+		if cfg.ASCII {
+			star = asciiPinned
+		}
+		emoji = strings.TrimSpace(star + " " + emoji)
+	}
+	account := fmt.Sprintf(" (%s)", profile.AWSAccountID)
+	if _, ok := accountNames[profile.AWSAccountID]; ok {
+		account = " - " + accountLabel(profile.AWSAccountID, accountNames)
+	}
+	name := profileLabel(profile.Name)
+	if width := terminalWidth(); width > 0 {
+		// Shorten long names so the account stays on screen.
+		available := width - optionChromeWidth - utf8.RuneCountInString(emoji+" "+account)
+		name = elideName(name, max(available, minElidedNameLength))
+	}
+	displayName := emoji + " " + profileStyle(profile.Name).Render(name) + account
+	if profile.Description != "" {
+		displayName += " - " + profile.Description
+	}
+	if !isCompleteProfile(profile) {
+		marker := "⚠"
+		if cfg.ASCII {
+			marker = asciiIncomplete
+		}
+		displayName += " " + marker + " incomplete"
+	}
+	return huh.NewOption(displayName, profile.Name)
+}
+
+const (
+	minMenuHeight = 5
+	// menuHeightReserve is the number of terminal rows left for the prompt
+	// title, the search input and the shell prompt when sizing the list to
+	// the terminal.
+	menuHeightReserve = 4
+)
+
+// resolveMenuHeight picks the number of rows for the selection list: the
+// -height flag if set, otherwise the terminal height less
+// menuHeightReserve. Results are clamped to minMenuHeight; 0 means the
+// terminal height is unknown and huh's default is used.
+func resolveMenuHeight(flagHeight, terminalHeight int) int {
+	height := flagHeight
+	if height == 0 {
+		if terminalHeight <= 0 {
+			return 0
+		}
+		height = terminalHeight - menuHeightReserve
+	}
+	if height < minMenuHeight {
+		return minMenuHeight
+	}
+	return height
+}
+
+func menuHeight() int {
+	_, terminalHeight, err := term.GetSize(infoOutput.Fd())
+	if err != nil {
+		terminalHeight = 0
+	}
+	return resolveMenuHeight(cfg.MenuHeight, terminalHeight)
+}
+
+func showInteractiveSearchPrompt(profiles map[string]AWSProfile) (string, error) {
+	var query string
+	var state promptState
+
+	matches := func() []AWSProfile {
+		matches := filterProfiles(profiles, query)
+		if cfg.SafeOrder && strings.TrimSpace(query) == "" {
+			matches = orderProdLast(matches)
+		}
+		return matches
+	}
+	topMatch := func() string {
+		if matches := matches(); len(matches) > 0 {
+			return matches[0].Name
+		}
+		return ""
+	}
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Search AWS profiles").
+				Description("enter selects the best match, tab moves to the list").
+				Value(&query),
+			huh.NewSelect[string]().
+				OptionsFunc(func() []huh.Option[string] {
+					var options []huh.Option[string]
+					for _, profile := range matches() {
+						options = append(options, profileOption(profile, false))
+					}
+					return options
+				}, &query).
+				DescriptionFunc(func() string {
+					return profileDescription(profiles, state)
+				}, &state).
+				Height(menuHeight()).
+				Value(&state.Selected),
+		),
+	).WithKeyMap(selectKeyMap()).WithOutput(infoOutput)
+
+	err := runProfilePrompt(form, profiles, &state, topMatch)
+	if err != nil {
+		return "", err
+	}
+
+	return state.Selected, nil
+}
+
+// profileDescription is the description of a profile prompt: the details of
+// the highlighted profile and any message flashed by runProfilePrompt.
+func profileDescription(profiles map[string]AWSProfile, state promptState) string {
+	details := profileDetails(profiles, state.Selected)
+	if state.Flash != "" {
+		details += "\n" + state.Flash
+	}
+	return details
+}
+
+// profileDetails describes the profile highlighted in a selection prompt.
+// It only uses what the credentials file says, without calling the AWS CLI,
+// because it runs every time the cursor moves.
+func profileDetails(profiles map[string]AWSProfile, name string) string {
+	profile, ok := profiles[name]
+	if !ok {
+		return ""
+	}
+	region := profile.Region
+	if region == "" {
+		region = envDefaultRegion(profileEnvironment(name))
+	}
+	role := "none"
+	if profile.RoleARN != "" {
+		role = profile.RoleARN + " via " + strings.Join(sourceChain(profiles, name), " → ")
+	}
+	return fmt.Sprintf("Account: %s\nRegion: %s\nEnvironment: %s\nRole: %s",
+		displayValue(accountLabel(profile.AWSAccountID, accountNames)), regionLabel(region),
+		profileEnvironment(name), role)
+}
+
+// sourceChain follows the source_profile settings starting at name's source
+// and returns the profiles passed through, stopping at a profile without a
+// source, one that isn't defined, or a cycle.
+func sourceChain(profiles map[string]AWSProfile, name string) []string {
+	var chain []string
+	seen := map[string]bool{name: true}
+	for source := profiles[name].SourceProfile; source != ""; source = profiles[source].SourceProfile {
+		chain = append(chain, source)
+		if seen[source] {
+			break
+		}
+		seen[source] = true
+	}
+	return chain
+}
+
+// chainOptions returns the profiles of name's source_profile chain that are
+// defined, starting with name itself, labelled with the role each assumes.
+func chainOptions(profiles map[string]AWSProfile, name string) []huh.Option[string] {
+	var options []huh.Option[string]
+	seen := make(map[string]bool)
+	for _, link := range append([]string{name}, sourceChain(profiles, name)...) {
+		profile, ok := profiles[link]
+		if !ok || seen[link] {
+			continue
+		}
+		seen[link] = true
+		label := link
+		if profile.RoleARN != "" {
+			label += " (assumes " + profile.RoleARN + ")"
+		}
+		options = append(options, huh.NewOption(label, link))
+	}
+	return options
+}
+
+func showChainSelectionPrompt(profileName string, options []huh.Option[string]) (string, error) {
+	selected := profileName
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("Use which profile of the chain?").
+				Description("Verifying each hop shows which one fails.").
+				Options(options...).
+				Value(&selected),
+		),
+	).WithKeyMap(selectKeyMap()).WithOutput(infoOutput)
+
+	if err := runForm(form); err != nil {
+		return "", err
+	}
+	return selected, nil
+}
+
+// confirmationSummary describes what using profile will do.
+func confirmationSummary(profile AWSProfile, region string) string {
+	assumeRole := "no"
+	if profile.RoleARN != "" {
+		assumeRole = "yes (" + profile.RoleARN + ")"
+	}
+	return fmt.Sprintf("Profile: %s\nAccount: %s\nRegion: %s\nAssume role: %s",
+		profile.Name, displayValue(profile.AWSAccountID), regionLabel(region), assumeRole)
+}
+
+// askConfirmation is confirmSelection, which tests replace as they have no
+// terminal to answer it on.
+var askConfirmation = confirmSelection
+
+// confirmSelection asks the user to confirm using profile. Esc cancels in
+// addition to the usual ctrl+c.
+func confirmSelection(profile AWSProfile, region string) (bool, error) {
+	confirmed := true
+	keyMap := huh.NewDefaultKeyMap()
+	keyMap.Quit = key.NewBinding(key.WithKeys("ctrl+c", "esc"))
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Use this profile?").
+				Description(confirmationSummary(profile, region)).
+				Affirmative("Continue").
+				Negative("Cancel").
+				Value(&confirmed),
+		),
+	).WithKeyMap(keyMap).WithOutput(infoOutput)
+
+	if err := runForm(form); err != nil {
+		return false, err
+	}
+	return confirmed, nil
+}
+
+var errNoPrompt = errors.New("a prompt is needed but -no-prompt is set; choose the profile with -profile or -l, or see them with -list")
+
+// runForm runs form unless prompts are disabled with -no-prompt. Every
+// prompt goes through it, or through runProfilePrompt, which checks the same.
+func runForm(form *huh.Form) error {
+	if cfg.NoPrompt {
+		return errNoPrompt
+	}
+	return form.Run()
+}
+
+// selectKeyMap returns the key map of the profile prompts, whose help footer
+// also shows the vim-style keys for moving. huh's select already wraps from
+// the last option to the first and back.
+func selectKeyMap() *huh.KeyMap {
+	keyMap := huh.NewDefaultKeyMap()
+	keyMap.Select.Up.SetHelp("↑/k", "up")
+	keyMap.Select.Down.SetHelp("↓/j", "down")
+	keyMap.Select.Filter.SetHelp("/", "filter")
+	return keyMap
+}
+
+func showProfileSelectionPrompt(profiles map[string]AWSProfile) (string, error) {
+	var options []huh.Option[string]
+
+	pinned := getPinnedProfiles()
+	isPinned := make(map[string]bool)
+	for _, name := range pinned {
+		isPinned[name] = true
+	}
+
+	ordered := filterProfiles(profiles, "")
+	if cfg.SafeOrder {
+		ordered = orderProdLast(ordered)
+	}
+	for _, profile := range orderPinnedFirst(ordered, pinned) {
+		options = append(options, profileOption(profile, isPinned[profile.Name]))
 	}
 
-	lastUsed := getLastUsedProfile()
-	var selectedProfile string = lastUsed
+	state := promptState{Selected: getLastUsedProfile()}
 
 	form := huh.NewForm(
 		huh.NewGroup(
 			huh.NewSelect[string]().
 				Title("Select an AWS profile").
+				DescriptionFunc(func() string {
+					return profileDescription(profiles, state)
+				}, &state).
 				Options(options...).
-				Value(&selectedProfile),
+				Height(menuHeight()).
+				Value(&state.Selected),
 		),
-	)
+	).WithKeyMap(selectKeyMap()).WithOutput(infoOutput)
 
-	err := form.Run()
+	err := runProfilePrompt(form, profiles, &state, nil)
 	if err != nil {
 		return "", err
 	}
 
-	return selectedProfile, nil
+	return state.Selected, nil
 }
 
-func selectAndUseProfile(profileName string) error {
-	if err := saveLastUsedProfile(profileName); err != nil {
-		return err
+// warnings holds every warning printed so far, which -strict turns into a
+// failure.
+var warnings []string
+
+// warn prints a warning to stderr and records it in warnings.
+func warn(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	warnings = append(warnings, message)
+	fmt.Fprintln(os.Stderr, "Warning: "+message)
+}
+
+// infoOutput receives informational output and prompts. It is stderr under
+// -export, -json and -grep so that stdout holds only the commands to eval,
+// the JSON document or the matching names.
+var infoOutput = os.Stdout
+
+// logInfo prints informational output, which -quiet suppresses.
+func logInfo(format string, args ...any) {
+	if !cfg.Quiet {
+		fmt.Fprintf(infoOutput, format, args...)
+	}
+}
+
+// clearExportLine unsets the variables set by exportLine.
+const clearExportLine = "unset AWS_PROFILE AWS_DEFAULT_REGION AWS_REGION"
+
+// exportLine returns the shell command that activates profile in the
+// calling shell.
+func exportLine(profile AWSProfile, region string) string {
+	if profile.Name == ambientProfile {
+		line := "unset AWS_PROFILE"
+		if region != "" {
+			line += "; export AWS_REGION=" + shellQuote(region) + " AWS_DEFAULT_REGION=" + shellQuote(region)
+		}
+		return line
 	}
+	line := "export AWS_PROFILE=" + shellQuote(profile.Name)
+	if region != "" {
+		line += " AWS_REGION=" + shellQuote(region) + " AWS_DEFAULT_REGION=" + shellQuote(region)
+	}
+	return line
+}
 
-	fmt.Printf("Selected profile: %s\n", profileName)
+var shellSafeRegexp = regexp.MustCompile(`^[A-Za-z0-9_./:@%+=,-]+$`)
+
+// shellQuote returns s as a single POSIX shell word, quoting it only when
+// it contains characters the shell would interpret.
+func shellQuote(s string) string {
+	if shellSafeRegexp.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
 
-	newRegion := getCurrentRegion()
-	fmt.Printf("New default region: %s\n", newRegion)
+func printJSONError(code int, message string) {
+	json.NewEncoder(os.Stdout).Encode(jsonError{Error: message, Code: code})
+}
 
-	useOnePassCLI := os.Getenv("USE_ONEPASS_CLI")
-	var cmd *exec.Cmd
+func selectAndUseProfile(profile AWSProfile, opts useOptions) error {
+	profileName := profile.Name
+	newRegion := opts.Region
 
-	if useOnePassCLI == "true" {
-		cmd = exec.Command("op", "run", "--", "aws", "sts", "get-caller-identity")
-	} else {
-		cmd = exec.Command("aws", "sts", "get-caller-identity")
+	if opts.SaveLastUsed {
+		if err := recordProfileUse(profileName); err != nil {
+			return err
+		}
 	}
 
-	cmd.Env = append(os.Environ(), fmt.Sprintf("AWS_PROFILE=%s", profileName))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("error executing AWS CLI command: %v", err)
+	if !opts.JSONOutput && !opts.Probe && !opts.PrintARN {
+		logInfo("Selected profile: %s\n", profileName)
+		if profile.Description != "" {
+			logInfo("Description: %s\n", profile.Description)
+		}
+		logInfo("New default region: %s\n", regionLabel(newRegion))
+		if opts.Verbose && profile.Region != "" {
+			if mismatch, message := regionMismatch(profile, getCurrentRegion(profileName)); mismatch {
+				logInfo("Region mismatch: %s\n", message)
+			}
+		}
+	}
+
+	var output []byte
+	var expiration time.Time
+	var verifyErr error
+	if opts.Verify {
+		assumeRole := opts.Duration > 0 && profile.RoleARN != ""
+		fresh := false
+		if !assumeRole {
+			output, fresh = freshVerification(loadVerifications(), profileName, opts.FreshWithin, time.Now())
+		}
+		switch {
+		case fresh:
+			if !opts.JSONOutput && !opts.Probe && !opts.PrintARN {
+				logInfo("Using a verification from the last %s\n", opts.FreshWithin)
+			}
+		case assumeRole:
+			output, expiration, verifyErr = assumeRoleIdentity(profile, opts.Duration)
+		default:
+			output, verifyErr = getCallerIdentity(profileName)
+			if verifyErr == nil && opts.SaveLastUsed {
+				if err := recordVerification(profileName, output, time.Now()); err != nil {
+					warn("error saving the verification: %v", err)
+				}
+			}
+		}
+	}
+	if opts.AuditLog != "" {
+		record := newAuditRecord(profile, newRegion, opts.Verify, output, verifyErr, time.Now())
+		if err := appendAuditRecord(opts.AuditLog, record); err != nil {
+			warn("error writing the audit log: %v", err)
+		}
+	}
+	if verifyErr != nil {
+		return fmt.Errorf("error executing AWS CLI command: %w", verifyErr)
+	}
+	if opts.SaveLastUsed {
+		if err := countProfileUse(profileName); err != nil {
+			warn("error counting the profile's use: %v", err)
+		}
+	}
+
+	switch {
+	case opts.Probe:
+		identity, err := parseCallerIdentity(output)
+		if err != nil {
+			return err
+		}
+		fmt.Println(identity.Account)
+	case opts.PrintARN:
+		identity, err := parseCallerIdentity(output)
+		if err != nil {
+			return err
+		}
+		fmt.Println(identity.Arn)
+	case opts.JSONOutput:
+		result := jsonResult{Profile: profileName, Region: newRegion}
+		if json.Valid(output) {
+			result.Identity = output
+		}
+		return json.NewEncoder(os.Stdout).Encode(result)
+	case opts.Verify:
+		logInfo("Command output: %s\n", output)
+		if opts.Verbose {
+			logInfo("Credentials provider: %s\n", inferProvider(profile))
+		}
 	}
 
-	fmt.Printf("Command output: %s\n", output)
+	if opts.Expires && !opts.Probe && !opts.PrintARN {
+		reportExpiry(profile, expiration, time.Now())
+	}
 	return nil
 }
+
+// reportExpiry prints how long profile's session has left, warning when it
+// is under expiryWarningThreshold. The expiration is known for sessions
+// assumed with -duration, which pass it in, and for SSO profiles with a
+// cached login; other credentials don't say when they expire.
+func reportExpiry(profile AWSProfile, expiration, now time.Time) {
+	if expiration.IsZero() && profile.SSOStartURL != "" {
+		expiration, _ = ssoTokenExpiry(ssoCacheDir(), profile.SSOStartURL, now)
+	}
+	if expiration.IsZero() {
+		logInfo("Session expires: unknown\n")
+		return
+	}
+	remaining := timeUntilExpiry(expiration, now)
+	logInfo("Session expires in %s (%s)\n", remaining.Round(time.Minute), expiration.Local().Format(time.Kitchen))
+	if remaining < expiryWarningThreshold {
+		warn("the session for %s expires in %s", profile.Name, remaining.Round(time.Second))
+	}
+}
+
+// verificationRequired reports whether a profile of environment class env
+// is verified, given the requested setting: always for the classes listed in
+// the always_verify setting, as requested otherwise.
+func verificationRequired(env string, verify bool) bool {
+	for _, always := range cfg.AlwaysVerify {
+		if always == env {
+			return true
+		}
+	}
+	return verify
+}
+
+// isCompleteProfile reports whether profile has some source of credentials:
+// the environment's for ambientProfile, static keys, a role to assume from a
+// source profile, SSO settings, or a credential process.
+func isCompleteProfile(p AWSProfile) bool {
+	return p.Name == ambientProfile ||
+		(p.AWSAccessKeyID != "" && p.AWSSecretAccessKey != "") ||
+		(p.RoleARN != "" && p.SourceProfile != "") ||
+		p.SSOSession != "" || p.SSOStartURL != "" || p.SSOAccountID != "" ||
+		p.CredentialProcess != ""
+}
+
+// inferProvider guesses which credential source satisfies profile from the
+// fields it sets.
+func inferProvider(p AWSProfile) string {
+	switch {
+	case p.Name == ambientProfile:
+		return "ambient"
+	case p.CredentialProcess != "":
+		return "credential_process"
+	case p.SSOSession != "" || p.SSOStartURL != "" || p.SSOAccountID != "":
+		return "sso"
+	case p.RoleARN != "" && p.SourceProfile != "":
+		return "assume role"
+	case p.AWSAccessKeyID != "" && p.AWSSecretAccessKey != "":
+		return "static keys"
+	}
+	return "unknown"
+}
+
+// configureRegionCommand builds the command that persists region as the
+// region of profileName.
+func configureRegionCommand(profileName, region string) *exec.Cmd {
+	return exec.Command(cfg.AWSCLIPath, "configure", "set", "region", region, "--profile", profileName)
+}
+
+func getCallerIdentity(profileName string) ([]byte, error) {
+	return callerIdentityCommand(profileName).CombinedOutput()
+}
+
+// callerIdentityCommand builds the STS verification command. The output
+// format is forced to JSON so parsing doesn't depend on the user's
+// configured default output.
+func callerIdentityCommand(profileName string) *exec.Cmd {
+	return awsCommand(profileName, "sts", "get-caller-identity", "--output", "json")
+}
+
+// awsCommand builds an AWS CLI command run as profileName, wrapped in
+// `op run` when the 1Password CLI is enabled.
+func awsCommand(profileName string, args ...string) *exec.Cmd {
+	args = append([]string{cfg.AWSCLIPath}, args...)
+	if cfg.UseOnePassCLI {
+		args = append([]string{cfg.OPCLIPath, "run", "--"}, args...)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = awsProfileEnv(os.Environ(), profileName)
+	return cmd
+}
+
+func parseCallerIdentity(output []byte) (callerIdentity, error) {
+	var identity callerIdentity
+	if err := json.Unmarshal(output, &identity); err != nil {
+		return identity, fmt.Errorf("error parsing caller identity: %v", err)
+	}
+	if identity.Account == "" {
+		return identity, fmt.Errorf("caller identity has no account id")
+	}
+	return identity, nil
+}
@@ -0,0 +1,1797 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// TestMain runs main instead of the tests when the test binary is started
+// by runMain, so that whole runs of the command can be tested.
+func TestMain(m *testing.M) {
+	if os.Getenv("AWS_LOGIN_TEST_MAIN") == "1" {
+		os.Args = append([]string{"aws-login"}, os.Args[1:]...)
+		if os.Getenv("AWS_LOGIN_TEST_DECLINE") == "1" {
+			askConfirmation = func(AWSProfile, string) (bool, error) { return false, nil }
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runResult is the outcome of a run of the command by runMain.
+type runResult struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// runMain runs the command with args, home as its home directory, stdin as
+// its input and env added to a copy of the test's environment without any
+// AWS or aws-login settings.
+func runMain(t *testing.T, home, stdin string, env []string, args ...string) runResult {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	for _, v := range os.Environ() {
+		name, _, _ := strings.Cut(v, "=")
+		if strings.HasPrefix(name, "AWS_") || name == "USE_ONEPASS_CLI" || name == "OP_CLI_PATH" || name == "XDG_CONFIG_HOME" {
+			continue
+		}
+		cmd.Env = append(cmd.Env, v)
+	}
+	cmd.Env = append(cmd.Env, "AWS_LOGIN_TEST_MAIN=1", "HOME="+home, "AWS_PROFILE_SELECTOR_HOME="+home)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("running aws-login %s: %v", strings.Join(args, " "), err)
+	}
+	return runResult{stdout: stdout.String(), stderr: stderr.String(), code: cmd.ProcessState.ExitCode()}
+}
+
+// writeFile writes content to path, creating its directory.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFile returns the content of path, or "" if it doesn't exist.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestReadCredentialsFileInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "credentials"), "[main]\nregion = us-east-1\n; include team/shared\n[after]\nregion = eu-west-1\n")
+	writeFile(t, filepath.Join(dir, "team", "shared"), "[shared]\nregion = us-west-2\n")
+
+	parser := newCredentialsParser(parseOptions{})
+	if err := readCredentialsFile(filepath.Join(dir, "credentials"), parser, map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"main": "us-east-1", "shared": "us-west-2", "after": "eu-west-1"}
+	if len(parser.profiles) != len(want) {
+		t.Errorf("got profiles %v, want %v", parser.profiles, want)
+	}
+	for name, region := range want {
+		if got := parser.profiles[name].Region; got != region {
+			t.Errorf("profile %s has region %q, want %q", name, got, region)
+		}
+	}
+}
+
+func TestParseIncludeDirective(t *testing.T) {
+	tests := []struct {
+		line string
+		file string
+		ok   bool
+	}{
+		{"; include team/shared", "team/shared", true},
+		{"  ;include shared  ", "shared", true},
+		{"# include foo", "", false},
+		{"; include", "", false},
+		{"; include a b", "", false},
+		{"; includes foo", "", false},
+		{"include foo", "", false},
+	}
+	for _, tt := range tests {
+		if file, ok := parseIncludeDirective(tt.line); file != tt.file || ok != tt.ok {
+			t.Errorf("parseIncludeDirective(%q) = %q, %v, want %q, %v", tt.line, file, ok, tt.file, tt.ok)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "credentials"), "[main]\n# include foo\n")
+	if err := readCredentialsFile(filepath.Join(dir, "credentials"), newCredentialsParser(parseOptions{}), map[string]bool{}); err != nil {
+		t.Errorf("a # comment was read as an include: %v", err)
+	}
+}
+
+func TestReadCredentialsFileCircularInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a"), "[a]\n; include b\n")
+	writeFile(t, filepath.Join(dir, "b"), "[b]\n; include a\n")
+
+	err := readCredentialsFile(filepath.Join(dir, "a"), newCredentialsParser(parseOptions{}), map[string]bool{})
+	if err == nil || !strings.Contains(err.Error(), "circular include") {
+		t.Errorf("got error %v, want a circular include error", err)
+	}
+}
+
+// testHome points homeDir at a new temporary directory for the rest of the
+// test and returns it.
+func testHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("AWS_PROFILE_SELECTOR_HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	return home
+}
+
+// setConfig replaces cfg with c for the rest of the test, and clears the
+// warnings printed so far.
+func setConfig(t *testing.T, c config) {
+	t.Helper()
+	saved, savedWarnings := cfg, warnings
+	t.Cleanup(func() { cfg, warnings = saved, savedWarnings })
+	cfg, warnings = c, nil
+}
+
+// profileNames returns the names of profiles in order.
+func profileNames(profiles []AWSProfile) []string {
+	var names []string
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+	return names
+}
+
+func TestFilterProfiles(t *testing.T) {
+	setConfig(t, defaultConfig())
+	profiles := map[string]AWSProfile{
+		"team-prod":    {Name: "team-prod"},
+		"team-dev":     {Name: "team-dev"},
+		"billing-prod": {Name: "billing-prod"},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"billing-prod", "team-dev", "team-prod"}},
+		{"  ", []string{"billing-prod", "team-dev", "team-prod"}},
+		{"team", []string{"team-dev", "team-prod"}},
+		{"prod", []string{"billing-prod", "team-prod"}},
+		{"team prod", []string{"team-prod"}},
+		{"BILLING", []string{"billing-prod"}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		if got := profileNames(filterProfiles(profiles, tt.query)); !slices.Equal(got, tt.want) {
+			t.Errorf("filterProfiles(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestPinnedProfilesRoundTrip(t *testing.T) {
+	testHome(t)
+	c := defaultConfig()
+	c.Quiet = true
+	setConfig(t, c)
+	profiles := map[string]AWSProfile{
+		"alpha":     {Name: "alpha"},
+		"beta":      {Name: "beta"},
+		"gamma":     {Name: "gamma"},
+		"team-prod": {Name: "team-prod"},
+	}
+
+	steps := []struct {
+		pin, unpin string
+		want       []string
+	}{
+		{pin: "gamma", want: []string{"gamma", "alpha", "beta", "team-prod"}},
+		{pin: "team-prod", want: []string{"gamma", "team-prod", "alpha", "beta"}},
+		{pin: "gamma", want: []string{"gamma", "team-prod", "alpha", "beta"}},
+		{unpin: "gamma", want: []string{"team-prod", "alpha", "beta", "gamma"}},
+		{unpin: "team-prod", want: []string{"alpha", "beta", "gamma", "team-prod"}},
+	}
+	for _, step := range steps {
+		if err := updatePinnedProfiles(profiles, step.pin, step.unpin); err != nil {
+			t.Fatal(err)
+		}
+		got := profileNames(orderPinnedFirst(filterProfiles(profiles, ""), getPinnedProfiles()))
+		if !slices.Equal(got, step.want) {
+			t.Errorf("after pin %q unpin %q: order %v, want %v", step.pin, step.unpin, got, step.want)
+		}
+	}
+
+	if err := updatePinnedProfiles(profiles, "missing", ""); err == nil {
+		t.Error("pinning a profile that doesn't exist succeeded")
+	}
+}
+
+func TestProfileOptionPinned(t *testing.T) {
+	c := defaultConfig()
+	c.ASCII = true
+	setConfig(t, c)
+
+	option := profileOption(AWSProfile{Name: "team-prod", AWSAccountID: "111111111111", AWSAccessKeyID: "AKIA", AWSSecretAccessKey: "secret"}, true)
+	if want := asciiPinned + " " + asciiProd + " "; !strings.HasPrefix(option.Key, want) {
+		t.Errorf("pinned option %q doesn't start with %q", option.Key, want)
+	}
+}
+
+func TestASCIIMarkers(t *testing.T) {
+	tests := []struct {
+		name  string
+		ascii string
+	}{
+		{"api-prod", asciiProd},
+		{"api-test", asciiTest},
+		{"sandbox", asciiOther},
+	}
+	for _, ascii := range []bool{false, true} {
+		c := defaultConfig()
+		c.ASCII = ascii
+		setConfig(t, c)
+		for _, tt := range tests {
+			marker := getProfileEmoji(tt.name)
+			option := profileOption(AWSProfile{Name: tt.name, AWSAccountID: "111111111111"}, true)
+			if ascii {
+				if marker != tt.ascii || !strings.HasPrefix(option.Key, asciiPinned+" "+tt.ascii+" ") {
+					t.Errorf("-ascii: %s has marker %q and option %q, want %q", tt.name, marker, option.Key, tt.ascii)
+				}
+			} else if strings.Contains(marker, "[") || strings.Contains(option.Key, asciiPinned) || strings.Contains(option.Key, tt.ascii) {
+				t.Errorf("without -ascii: %s has marker %q and option %q", tt.name, marker, option.Key)
+			}
+		}
+	}
+}
+
+func TestASCIITerminal(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"TERM": "dumb"}, true},
+		{map[string]string{"TERM": "xterm-256color", "LANG": "en_US.UTF-8"}, false},
+		{map[string]string{"LANG": "en_US.utf8"}, false},
+		{map[string]string{"LANG": "C"}, true},
+		{map[string]string{"LC_ALL": "POSIX", "LANG": "en_US.UTF-8"}, true},
+		{map[string]string{"LC_CTYPE": "en_US.UTF-8", "LANG": "C"}, false},
+	}
+	for _, tt := range tests {
+		getenv := func(name string) string { return tt.env[name] }
+		if got := asciiTerminal(getenv); got != tt.want {
+			t.Errorf("asciiTerminal(%v) = %v, want %v", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestProfileOverrides(t *testing.T) {
+	c := defaultConfig()
+	c.ASCII = true
+	c.Profiles = map[string]profileConfig{
+		"shared-prod": {Icon: "!!", Color: "13"},
+		"sandbox":     {Color: "#00ff00"},
+	}
+	setConfig(t, c)
+
+	tests := []struct {
+		name  string
+		icon  string
+		color lipgloss.TerminalColor
+	}{
+		{"shared-prod", "!!", lipgloss.Color("13")},
+		{"billing-prod", asciiProd, lipgloss.NoColor{}},
+		{"sandbox", asciiOther, lipgloss.Color("#00ff00")},
+		{"api-test", asciiTest, lipgloss.NoColor{}},
+	}
+	for _, tt := range tests {
+		if got := profileIcon(tt.name); got != tt.icon {
+			t.Errorf("profileIcon(%q) = %q, want %q", tt.name, got, tt.icon)
+		}
+		if got := profileStyle(tt.name).GetForeground(); got != tt.color {
+			t.Errorf("profileStyle(%q) color %v, want %v", tt.name, got, tt.color)
+		}
+	}
+
+	option := profileOption(AWSProfile{Name: "shared-prod", AWSAccountID: "111111111111"}, true)
+	if want := asciiPinned + " !! "; !strings.HasPrefix(option.Key, want) {
+		t.Errorf("pinned option %q doesn't start with %q", option.Key, want)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+		code        int
+		message     string
+	}{
+		{"file not found", "", exitFileNotFound, "Error reading AWS credentials"},
+		{"no profiles", "# nothing here\n", exitNoProfiles, "No AWS profiles found."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			if tt.credentials != "" {
+				writeFile(t, filepath.Join(home, ".aws", "credentials"), tt.credentials)
+			}
+			result := runMain(t, home, "", nil, "-json", "-list")
+			if result.code != tt.code {
+				t.Errorf("exit code %d, want %d", result.code, tt.code)
+			}
+			var got map[string]any
+			if err := json.Unmarshal([]byte(result.stdout), &got); err != nil {
+				t.Fatalf("stdout %q isn't JSON: %v", result.stdout, err)
+			}
+			if len(got) != 2 || got["code"] != float64(tt.code) {
+				t.Errorf("got %v, want only error and code %d", got, tt.code)
+			}
+			if message, _ := got["error"].(string); !strings.HasPrefix(message, tt.message) {
+				t.Errorf("error %q doesn't start with %q", message, tt.message)
+			}
+		})
+	}
+}
+
+func TestSearchProfilesWeights(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"west-tools": {Name: "west-tools", Region: "eu-central-1"},
+		"api":        {Name: "api", Region: "us-west-2"},
+	}
+	tests := []struct {
+		name    string
+		weights rankWeights
+		want    []string
+	}{
+		{"default", defaultRankWeights, []string{"west-tools", "api"}},
+		{"region outweighs name", rankWeights{Substring: 1, Region: 3}, []string{"api", "west-tools"}},
+		{"region ignored", rankWeights{Substring: 1}, []string{"west-tools"}},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		c.Weights = tt.weights
+		setConfig(t, c)
+		if got := profileNames(searchProfiles(profiles, "west")); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: searchProfiles = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchProfilesByAccountID(t *testing.T) {
+	setConfig(t, defaultConfig())
+	profiles := map[string]AWSProfile{
+		"billing": {Name: "billing", AWSAccountID: "111122223333"},
+		"sandbox": {Name: "sandbox", AWSAccountID: "444455556666"},
+		"legacy":  {Name: "legacy"},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"111122223333", []string{"billing"}},
+		{"5555", []string{"sandbox"}},
+		{"22223333 billing", []string{"billing"}},
+		{"999999999999", nil},
+	}
+	for _, tt := range tests {
+		if got := profileNames(searchProfiles(profiles, tt.query)); !slices.Equal(got, tt.want) {
+			t.Errorf("searchProfiles(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// homeFiles returns the content of every file under home by path.
+func homeFiles(t *testing.T, home string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(home, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		files[path] = readFile(t, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestNoLastSave(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[alpha]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n[beta]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	writeFile(t, filepath.Join(home, lastUsedFile), "alpha")
+	before := homeFiles(t, home)
+
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, callerIdentityScript)}
+	for _, args := range [][]string{
+		{"-profile", "beta", "-no-verify", "-no-last-save"},
+		{"-profile", "beta", "-no-last-save"},
+	} {
+		if result := runMain(t, home, "", env, args...); result.code != 0 {
+			t.Fatalf("%v: exit code %d: %s%s", args, result.code, result.stdout, result.stderr)
+		}
+		if after := homeFiles(t, home); !maps.Equal(after, before) {
+			t.Errorf("%v: files changed: %v, want %v", args, after, before)
+		}
+	}
+
+	if result := runMain(t, home, "", nil, "-profile", "beta", "-no-verify"); result.code != 0 {
+		t.Fatalf("exit code %d: %s", result.code, result.stdout)
+	}
+	if got := readFile(t, filepath.Join(home, lastUsedFile)); got != "beta" {
+		t.Errorf("last used profile %q without -no-last-save, want beta", got)
+	}
+}
+
+// writeLargeCredentialsFile writes a credentials file with n profiles to
+// path.
+func writeLargeCredentialsFile(tb testing.TB, path string, n int) {
+	tb.Helper()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "# profile number %d\n[profile-%d]\naws_access_key_id = AKIA%016d\naws_secret_access_key = %040d\nregion = us-east-1\n\n", i, i, i, i)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// maxAllocsPerProfile bounds the allocations BenchmarkLoadLargeCredentials
+// accepts for each profile: parsing a line shouldn't allocate more than a
+// few small strings, and the file must never be held in memory whole.
+const maxAllocsPerProfile = 20
+
+func BenchmarkLoadLargeCredentials(b *testing.B) {
+	const profileCount = 50000
+	path := filepath.Join(b.TempDir(), "credentials")
+	writeLargeCredentialsFile(b, path, profileCount)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < b.N; i++ {
+		parser := newCredentialsParser(parseOptions{})
+		if err := readCredentialsFile(path, parser, map[string]bool{}); err != nil {
+			b.Fatal(err)
+		}
+		if len(parser.profiles) != profileCount {
+			b.Fatalf("got %d profiles, want %d", len(parser.profiles), profileCount)
+		}
+	}
+	runtime.ReadMemStats(&after)
+
+	perProfile := float64(after.Mallocs-before.Mallocs) / float64(b.N) / profileCount
+	b.ReportMetric(perProfile, "allocs/profile")
+	if perProfile > maxAllocsPerProfile {
+		b.Errorf("%.1f allocations per profile, want at most %d", perProfile, maxAllocsPerProfile)
+	}
+}
+
+func TestRenameProfileState(t *testing.T) {
+	home := testHome(t)
+	writeFile(t, filepath.Join(home, lastUsedFile), "old")
+	writeFile(t, filepath.Join(home, pinnedFile), "other\nold\n")
+	writeFile(t, filepath.Join(home, historyFile), "old 100\nother 50\n")
+	writeFile(t, filepath.Join(home, regionsFile), "old eu-west-1\n")
+
+	if err := renameProfileState("old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if got := getLastUsedProfile(); got != "new" {
+		t.Errorf("last used profile %q, want new", got)
+	}
+	if got := getPinnedProfiles(); !slices.Equal(got, []string{"other", "new"}) {
+		t.Errorf("pinned profiles %v, want [other new]", got)
+	}
+	if got := getProfileHistory(); len(got) != 2 || got[0].Name != "new" || got[0].UsedAt.Unix() != 100 {
+		t.Errorf("history %v, want new first, still used at 100", got)
+	}
+	if got := getRememberedRegions(); len(got) != 1 || got["new"] != "eu-west-1" {
+		t.Errorf("remembered regions %v, want only new: eu-west-1", got)
+	}
+}
+
+// fakeCLI writes an executable shell script with the given body, standing
+// in for the AWS or 1Password CLI, and returns its path.
+func fakeCLI(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fake-cli")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// callerIdentityScript is a fake AWS CLI answering get-caller-identity, and
+// failing anything else, such as looking up a configured region.
+const callerIdentityScript = `case "$1 $2" in
+"sts get-caller-identity") echo '{"UserId": "AIDAEXAMPLE", "Account": "123456789012", "Arn": "arn:aws:iam::123456789012:user/dev"}' ;;
+*) exit 1 ;;
+esac
+`
+
+func TestProbe(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n")
+	aws := fakeCLI(t, callerIdentityScript)
+
+	result := runMain(t, home, "", []string{"AWS_CLI_PATH=" + aws}, "-profile", "dev", "-probe")
+	if result.code != 0 {
+		t.Fatalf("exit code %d: %s%s", result.code, result.stdout, result.stderr)
+	}
+	if result.stdout != "123456789012\n" {
+		t.Errorf("stdout %q, want only the account id", result.stdout)
+	}
+}
+
+func TestFilterAllowedProfiles(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"team-dev":     {Name: "team-dev"},
+		"team-prod":    {Name: "team-prod"},
+		"billing-prod": {Name: "billing-prod"},
+		"sandbox":      {Name: "sandbox"},
+	}
+	tests := []struct {
+		name        string
+		allow, deny []string
+		want        []string
+	}{
+		{"no lists", nil, nil, []string{"billing-prod", "sandbox", "team-dev", "team-prod"}},
+		{"allow only", []string{"team-*", "sandbox"}, nil, []string{"sandbox", "team-dev", "team-prod"}},
+		{"deny only", nil, []string{"*-prod"}, []string{"sandbox", "team-dev"}},
+		{"deny wins over allow", []string{"team-*"}, []string{"*-prod"}, []string{"team-dev"}},
+	}
+	for _, tt := range tests {
+		filtered := filterAllowedProfiles(profiles, tt.allow, tt.deny)
+		if got := profileNames(filterProfiles(filtered, "")); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// flaggedFields returns the fields diffProfiles marked as differing.
+func flaggedFields(diff string) []string {
+	var flagged []string
+	for _, line := range strings.Split(diff, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "*" {
+			flagged = append(flagged, fields[1])
+		}
+	}
+	return flagged
+}
+
+func TestDiffProfiles(t *testing.T) {
+	base := AWSProfile{Name: "a", AWSAccountID: "111111111111", Region: "us-east-1", AWSAccessKeyID: "AKIAONE", AWSSecretAccessKey: "secret-one"}
+	same := base
+	same.Name = "b"
+	other := same
+	other.Region = "eu-west-1"
+	other.AWSSecretAccessKey = "secret-two"
+
+	tests := []struct {
+		name string
+		b    AWSProfile
+		want []string
+	}{
+		{"identical", same, nil},
+		{"differing", other, []string{"region", "aws_secret_access_key"}},
+	}
+	for _, tt := range tests {
+		diff := diffProfiles(base, tt.b)
+		if got := flaggedFields(diff); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: flagged %v, want %v\n%s", tt.name, got, tt.want, diff)
+		}
+		for _, secret := range []string{"AKIAONE", "secret-one", "secret-two"} {
+			if strings.Contains(diff, secret) {
+				t.Errorf("%s: diff shows %q", tt.name, secret)
+			}
+		}
+	}
+}
+
+func TestAWSCLIPath(t *testing.T) {
+	testHome(t)
+	t.Setenv("AWS_CLI_PATH", "/opt/aws-cli/bin/aws")
+	c, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, c)
+
+	if got := callerIdentityCommand("dev").Args[0]; got != "/opt/aws-cli/bin/aws" {
+		t.Errorf("command runs %q, want the AWS_CLI_PATH binary", got)
+	}
+	cfg.UseOnePassCLI = true
+	cfg.OPCLIPath = "/opt/op"
+	if got := callerIdentityCommand("dev").Args; !slices.Equal(got[:4], []string{"/opt/op", "run", "--", "/opt/aws-cli/bin/aws"}) {
+		t.Errorf("command %v, want the op and aws binaries from the config", got)
+	}
+}
+
+func TestConfirmationSummary(t *testing.T) {
+	tests := []struct {
+		profile AWSProfile
+		region  string
+		want    string
+	}{
+		{
+			AWSProfile{Name: "dev", AWSAccountID: "111111111111"},
+			"us-east-1",
+			"Profile: dev\nAccount: 111111111111\nRegion: us-east-1\nAssume role: no",
+		},
+		{
+			AWSProfile{Name: "admin", RoleARN: "arn:aws:iam::222222222222:role/admin", SourceProfile: "dev"},
+			"",
+			"Profile: admin\nAccount: -\nRegion: Not set\nAssume role: yes (arn:aws:iam::222222222222:role/admin)",
+		},
+	}
+	for _, tt := range tests {
+		if got := confirmationSummary(tt.profile, tt.region); got != tt.want {
+			t.Errorf("confirmationSummary(%s) =\n%s\nwant:\n%s", tt.profile.Name, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmCancelled(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n")
+	env := []string{"AWS_LOGIN_TEST_DECLINE=1"}
+
+	result := runMain(t, home, "", env, "-profile", "dev", "-confirm", "-no-verify", "-json")
+	if result.code != exitError || result.stdout != "{\"error\":\"Cancelled.\",\"code\":1}\n" {
+		t.Errorf("-json: exit code %d, stdout %q, want a JSON error", result.code, result.stdout)
+	}
+	if strings.Contains(result.stderr, "Cancelled") {
+		t.Errorf("-json: stderr %q repeats the cancellation", result.stderr)
+	}
+
+	result = runMain(t, home, "", env, "-profile", "dev", "-confirm", "-no-verify")
+	if result.code != 0 || result.stdout != "Cancelled.\n" {
+		t.Errorf("exit code %d, stdout %q, want a message", result.code, result.stdout)
+	}
+	if _, err := os.Stat(filepath.Join(home, lastUsedFile)); err == nil {
+		t.Error("a cancelled selection was recorded as used")
+	}
+}
+
+func TestLoadProfilesRetriesMidEdit(t *testing.T) {
+	home := testHome(t)
+	setConfig(t, defaultConfig())
+	path := filepath.Join(home, ".aws", "credentials")
+	// The file as another process has started to write it: not empty, but
+	// without a profile yet.
+	writeFile(t, path, "# managed by a script\n")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(loadRetryDelay / 4)
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte("# managed by a script\n[dev]\naws_access_key_id = AKIA1\n"), 0600); err == nil {
+			os.Rename(tmp, path)
+		}
+	}()
+
+	profiles, err := loadProfiles(parseOptions{})
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := profiles["dev"]; !ok || len(profiles) != 1 {
+		t.Errorf("got profiles %v, want dev from the rewritten file", profiles)
+	}
+}
+
+func TestLoadProfilesEmptyFile(t *testing.T) {
+	home := testHome(t)
+	setConfig(t, defaultConfig())
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "")
+
+	start := time.Now()
+	profiles, err := loadProfiles(parseOptions{})
+	if err != nil || len(profiles) != 0 {
+		t.Errorf("got %v, %v, want no profiles", profiles, err)
+	}
+	if elapsed := time.Since(start); elapsed >= loadRetryDelay {
+		t.Errorf("an empty file took %v, want no retries", elapsed)
+	}
+}
+
+func TestFilterByAccount(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"billing":       {Name: "billing", AWSAccountID: "111111111111"},
+		"shared-admin":  {Name: "shared-admin", AWSAccountID: "222222222222"},
+		"shared-reader": {Name: "shared-reader", AWSAccountID: "222222222222"},
+	}
+	tests := []struct {
+		account string
+		want    []string
+	}{
+		{"111111111111", []string{"billing"}},
+		{"222222222222", []string{"shared-admin", "shared-reader"}},
+		{"333333333333", nil},
+	}
+	for _, tt := range tests {
+		if got := profileNames(filterProfiles(filterByAccount(profiles, tt.account), "")); !slices.Equal(got, tt.want) {
+			t.Errorf("filterByAccount(%s) = %v, want %v", tt.account, got, tt.want)
+		}
+	}
+}
+
+func TestAccountSelect(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[billing]\naws_account_id = 111111111111\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n[shared-admin]\naws_account_id = 222222222222\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n[shared-reader]\naws_account_id = 222222222222\naws_access_key_id = AKIA3\naws_secret_access_key = s3\n")
+
+	result := runMain(t, home, "", nil, "-account-select", "111111111111", "-no-verify", "-no-last-save")
+	if result.code != 0 || !strings.Contains(result.stdout, "Selected profile: billing\n") {
+		t.Errorf("one match: exit code %d, output %q, want billing selected without a prompt", result.code, result.stdout)
+	}
+
+	// Several matches need a prompt, which -no-prompt turns into an error.
+	result = runMain(t, home, "", nil, "-account-select", "222222222222", "-no-verify", "-no-prompt")
+	if result.code != exitError || !strings.Contains(result.stdout, errNoPrompt.Error()) {
+		t.Errorf("several matches: exit code %d, output %q, want the prompt to be needed", result.code, result.stdout)
+	}
+}
+
+func TestLastNProfiles(t *testing.T) {
+	history := pushProfileHistory(nil, "alpha", time.Unix(100, 0))
+	history = pushProfileHistory(history, "beta", time.Unix(200, 0))
+	history = pushProfileHistory(history, "alpha", time.Unix(300, 0))
+
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{1, []string{"alpha"}},
+		{2, []string{"alpha", "beta"}},
+		{10, []string{"alpha", "beta"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, entry := range lastNProfiles(history, tt.n) {
+			got = append(got, entry.Name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("lastNProfiles(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProfileDescriptionComments(t *testing.T) {
+	content := `# Billing account
+[billing]
+region = us-east-1
+
+; Shared sandbox,
+; reset every night
+[sandbox]
+
+# Not attached: a blank line follows
+
+[plain]
+`
+	profiles := parseAWSCredentials(content, parseOptions{})
+	for name, want := range map[string]string{
+		"billing": "Billing account",
+		"sandbox": "Shared sandbox, reset every night",
+		"plain":   "",
+	} {
+		if got := profiles[name].Description; got != want {
+			t.Errorf("description of %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSearchProfilesTieBreak(t *testing.T) {
+	setConfig(t, defaultConfig())
+	profiles := map[string]AWSProfile{
+		"zeta-api":  {Name: "zeta-api"},
+		"alpha-api": {Name: "alpha-api"},
+		"mid-api":   {Name: "mid-api"},
+	}
+	want := []string{"alpha-api", "mid-api", "zeta-api"}
+	// Map iteration order varies, so repeat to catch an unstable sort.
+	for i := 0; i < 20; i++ {
+		if got := profileNames(searchProfiles(profiles, "api")); !slices.Equal(got, want) {
+			t.Fatalf("searchProfiles = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParseNestedSettings(t *testing.T) {
+	content := `[dev]
+region = us-east-1
+s3 =
+    max_concurrent_requests = 20
+    region = eu-west-1
+aws_access_key_id = AKIA1
+[next]
+region = ap-south-1
+`
+	profiles := parseAWSCredentials(content, parseOptions{})
+	if got := profiles["dev"]; got.Region != "us-east-1" || got.AWSAccessKeyID != "AKIA1" {
+		t.Errorf("dev = %+v, want region us-east-1 and the access key after the s3 block", got)
+	}
+	if got := profiles["next"].Region; got != "ap-south-1" {
+		t.Errorf("next has region %q, want ap-south-1", got)
+	}
+}
+
+func TestProfileCacheRoundTrip(t *testing.T) {
+	testHome(t)
+	profiles := map[string]AWSProfile{
+		"dev":   {Name: "dev", AWSAccountID: "111111111111", Region: "us-east-1", AWSAccessKeyID: "AKIA1", AWSSecretAccessKey: "wJalrXUtnFEMI", Description: "Development"},
+		"admin": {Name: "admin", RoleARN: "arn:aws:iam::111111111111:role/admin", SourceProfile: "dev"},
+	}
+	if err := saveCachedProfiles(profiles); err != nil {
+		t.Fatal(err)
+	}
+	if content := readFile(t, cacheFilePath()); strings.Contains(content, "AKIA1") || strings.Contains(content, "wJalrXUtnFEMI") {
+		t.Errorf("cache holds credentials: %s", content)
+	}
+
+	cached, err := loadCachedProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]AWSProfile{
+		"dev":   {Name: "dev", AWSAccountID: "111111111111", Region: "us-east-1", AWSAccessKeyID: redactedValue, AWSSecretAccessKey: redactedValue, Description: "Development"},
+		"admin": profiles["admin"],
+	}
+	if !maps.Equal(cached, want) {
+		t.Errorf("cached profiles %+v, want %+v", cached, want)
+	}
+}
+
+func TestInferProvider(t *testing.T) {
+	tests := []struct {
+		profile AWSProfile
+		want    string
+	}{
+		{AWSProfile{Name: ambientProfile}, "ambient"},
+		{AWSProfile{Name: "p", CredentialProcess: "/usr/bin/creds", AWSAccessKeyID: "AKIA1", AWSSecretAccessKey: "s"}, "credential_process"},
+		{AWSProfile{Name: "p", SSOStartURL: "https://example.awsapps.com/start"}, "sso"},
+		{AWSProfile{Name: "p", SSOSession: "corp"}, "sso"},
+		{AWSProfile{Name: "p", SSOAccountID: "111111111111", RoleARN: "arn:aws:iam::1:role/r", SourceProfile: "base"}, "sso"},
+		{AWSProfile{Name: "p", RoleARN: "arn:aws:iam::1:role/r", SourceProfile: "base"}, "assume role"},
+		{AWSProfile{Name: "p", RoleARN: "arn:aws:iam::1:role/r"}, "unknown"},
+		{AWSProfile{Name: "p", AWSAccessKeyID: "AKIA1", AWSSecretAccessKey: "s"}, "static keys"},
+		{AWSProfile{Name: "p", AWSAccessKeyID: "AKIA1"}, "unknown"},
+		{AWSProfile{Name: "p"}, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := inferProvider(tt.profile); got != tt.want {
+			t.Errorf("inferProvider(%+v) = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "# Development\n[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n")
+
+	result := runMain(t, home, "", nil, "-quiet", "-profile", "dev", "-no-verify")
+	if result.code != 0 || result.stdout != "" {
+		t.Errorf("exit code %d, stdout %q, want success with no output", result.code, result.stdout)
+	}
+	if got := readFile(t, filepath.Join(home, lastUsedFile)); got != "dev" {
+		t.Errorf("last used profile %q, want dev: -quiet shouldn't change what is done", got)
+	}
+}
+
+func TestConfigureCommands(t *testing.T) {
+	c := defaultConfig()
+	c.AWSCLIPath = "/usr/local/bin/aws"
+	setConfig(t, c)
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"region", configureRegionCommand("dev", "eu-west-1").Args, []string{"/usr/local/bin/aws", "configure", "set", "region", "eu-west-1", "--profile", "dev"}},
+	}
+	for _, tt := range tests {
+		if !slices.Equal(tt.args, tt.want) {
+			t.Errorf("%s command %q, want %q", tt.name, tt.args, tt.want)
+		}
+	}
+}
+
+func TestParseBOM(t *testing.T) {
+	home := testHome(t)
+	setConfig(t, defaultConfig())
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "\uFEFF[first]\nregion = us-east-1\n[second]\ndescription = caf\xe9\nregion = eu-west-1\n")
+
+	profiles, err := loadProfiles(parseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := profiles["first"].Region; got != "us-east-1" {
+		t.Errorf("first profile has region %q, want us-east-1 despite the BOM", got)
+	}
+	if got := profiles["second"].Region; got != "eu-west-1" {
+		t.Errorf("second profile has region %q, want eu-west-1 after invalid UTF-8", got)
+	}
+}
+
+func TestResolveMenuHeight(t *testing.T) {
+	tests := []struct {
+		name                       string
+		flagHeight, terminalHeight int
+		want                       int
+	}{
+		{"flag", 12, 50, 12},
+		{"flag without a terminal", 12, 0, 12},
+		{"flag clamped", 2, 50, minMenuHeight},
+		{"fit the terminal", 0, 30, 30 - menuHeightReserve},
+		{"small terminal clamped", 0, 6, minMenuHeight},
+		{"no terminal", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := resolveMenuHeight(tt.flagHeight, tt.terminalHeight); got != tt.want {
+			t.Errorf("%s: resolveMenuHeight(%d, %d) = %d, want %d", tt.name, tt.flagHeight, tt.terminalHeight, got, tt.want)
+		}
+	}
+}
+
+func TestCredentialProcess(t *testing.T) {
+	profiles := parseAWSCredentials("[vault]\ncredential_process = /usr/local/bin/vault-creds --role dev\n", parseOptions{})
+	profile := profiles["vault"]
+	if profile.CredentialProcess != "/usr/local/bin/vault-creds --role dev" {
+		t.Errorf("credential_process = %q", profile.CredentialProcess)
+	}
+	if !isCompleteProfile(profile) {
+		t.Error("a credential_process profile is reported as incomplete")
+	}
+	if got := inferProvider(profile); got != "credential_process" {
+		t.Errorf("inferProvider = %q, want credential_process", got)
+	}
+}
+
+func TestRegionOnly(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n[other]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	writeFile(t, filepath.Join(home, lastUsedFile), "dev")
+	writeFile(t, filepath.Join(home, historyFile), "dev 100\nother 50\n")
+
+	result := runMain(t, home, "", nil, "-region-only", "-region", "eu-west2")
+	if result.code != 0 {
+		t.Fatalf("exit code %d: %s", result.code, result.stdout)
+	}
+	if got := readFile(t, filepath.Join(home, regionsFile)); got != "dev eu-west-2\n" {
+		t.Errorf("remembered regions %q, want dev eu-west-2", got)
+	}
+	if got := readFile(t, filepath.Join(home, lastUsedFile)); got != "dev" {
+		t.Errorf("last used profile %q, want it unchanged", got)
+	}
+	if got := readFile(t, filepath.Join(home, historyFile)); got != "dev 100\nother 50\n" {
+		t.Errorf("history %q, want it unchanged", got)
+	}
+
+	result = runMain(t, home, "", nil, "-which", "-json")
+	if want := `{"profile":"dev","region":"eu-west-2","source":"state"}` + "\n"; result.stdout != want {
+		t.Errorf("-which -json = %q, want %q", result.stdout, want)
+	}
+
+	os.Remove(filepath.Join(home, lastUsedFile))
+	if result := runMain(t, home, "", nil, "-region-only", "-region", "eu-west-2"); result.code != exitError {
+		t.Errorf("without an active profile: exit code %d, want %d", result.code, exitError)
+	}
+}
+
+func TestIsCompleteProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile AWSProfile
+		want    bool
+	}{
+		{"ambient", AWSProfile{Name: ambientProfile}, true},
+		{"static keys", AWSProfile{Name: "p", AWSAccessKeyID: "AKIA1", AWSSecretAccessKey: "s"}, true},
+		{"assume role", AWSProfile{Name: "p", RoleARN: "arn:aws:iam::1:role/r", SourceProfile: "base"}, true},
+		{"sso session", AWSProfile{Name: "p", SSOSession: "corp"}, true},
+		{"sso start url", AWSProfile{Name: "p", SSOStartURL: "https://example.awsapps.com/start"}, true},
+		{"credential process", AWSProfile{Name: "p", CredentialProcess: "creds"}, true},
+		{"access key without secret", AWSProfile{Name: "p", AWSAccessKeyID: "AKIA1"}, false},
+		{"role without source", AWSProfile{Name: "p", RoleARN: "arn:aws:iam::1:role/r"}, false},
+		{"region only", AWSProfile{Name: "p", Region: "us-east-1"}, false},
+	}
+	for _, tt := range tests {
+		if got := isCompleteProfile(tt.profile); got != tt.want {
+			t.Errorf("%s: isCompleteProfile = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIncompleteProfileWarning(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[complete]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n[broken]\naws_access_key_id = AKIA2\n")
+
+	result := runMain(t, home, "", nil, "-list")
+	if result.code != 0 || result.stdout != "broken\ncomplete\n" {
+		t.Errorf("exit code %d, output %q, want both profiles listed", result.code, result.stdout)
+	}
+	if want := "Warning: profile broken has no usable credentials\n"; result.stderr != want {
+		t.Errorf("stderr %q, want only %q", result.stderr, want)
+	}
+
+	// Static keys read through the AWS CLI are complete too, though the
+	// secret's value isn't kept.
+	result = runMain(t, home, "", []string{"AWS_CLI_PATH=" + fakeCLI(t, configureScript)}, "-use-aws-cli", "-list")
+	if result.code != 0 || result.stderr != "" {
+		t.Errorf("-use-aws-cli: exit code %d, stderr %q, want no warnings", result.code, result.stderr)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n[other]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	writeFile(t, filepath.Join(home, historyFile), "other 200\ndev 100\n")
+
+	start := time.Now().Truncate(time.Second)
+	if result := runMain(t, home, "", nil, "-touch", "dev"); result.code != 0 || result.stdout != "" {
+		t.Fatalf("exit code %d, output %q", result.code, result.stdout)
+	}
+	t.Setenv("AWS_PROFILE_SELECTOR_HOME", home)
+	history := getProfileHistory()
+	if len(history) != 2 || history[0].Name != "dev" || history[0].UsedAt.Before(start) {
+		t.Errorf("history %v, want dev first, used no earlier than %v", history, start)
+	}
+	if got := getLastUsedProfile(); got != "dev" {
+		t.Errorf("last used profile %q, want dev", got)
+	}
+
+	if result := runMain(t, home, "", nil, "-touch", "missing"); result.code != exitError {
+		t.Errorf("touching a missing profile: exit code %d, want %d", result.code, exitError)
+	}
+}
+
+func TestDuplicateAccessKeyWarning(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[one]\naws_access_key_id = AKIASHARED\naws_secret_access_key = s1\n[two]\naws_access_key_id = AKIASHARED\naws_secret_access_key = s1\n[three]\naws_access_key_id = AKIAOWN\naws_secret_access_key = s3\n")
+
+	result := runMain(t, home, "", nil, "-list")
+	if want := "Warning: profiles one, two share the same aws_access_key_id\n"; result.stderr != want {
+		t.Errorf("stderr %q, want %q", result.stderr, want)
+	}
+	if output := result.stdout + result.stderr; strings.Contains(output, "AKIA") {
+		t.Errorf("output shows an access key: %q", output)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"acme-dev":  {Name: "acme-dev"},
+		"acme-prod": {Name: "acme-prod"},
+		"acme":      {Name: "acme"},
+		"other-dev": {Name: "other-dev"},
+	}
+	if got := profileNames(filterProfiles(filterByPrefix(profiles, "acme-"), "")); !slices.Equal(got, []string{"acme-dev", "acme-prod"}) {
+		t.Errorf("filterByPrefix(acme-) = %v, want the acme- profiles", got)
+	}
+	if got := filterByPrefix(profiles, ""); len(got) != len(profiles) {
+		t.Errorf("an empty prefix kept %d of %d profiles", len(got), len(profiles))
+	}
+
+	tests := []struct {
+		prefix string
+		strip  bool
+		name   string
+		want   string
+	}{
+		{"acme-", true, "acme-dev", "dev"},
+		{"acme-", false, "acme-dev", "acme-dev"},
+		{"acme", true, "acme", "acme"},
+		{"", true, "acme-dev", "acme-dev"},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		c.Prefix, c.StripPrefix = tt.prefix, tt.strip
+		setConfig(t, c)
+		if got := profileLabel(tt.name); got != tt.want {
+			t.Errorf("profileLabel(%q) with prefix %q, strip %v = %q, want %q", tt.name, tt.prefix, tt.strip, got, tt.want)
+		}
+	}
+}
+
+func TestClearExport(t *testing.T) {
+	result := runMain(t, t.TempDir(), "", nil, "-clear-export")
+	if want := "unset AWS_PROFILE AWS_DEFAULT_REGION AWS_REGION\n"; result.code != 0 || result.stdout != want {
+		t.Errorf("exit code %d, stdout %q, want only %q", result.code, result.stdout, want)
+	}
+}
+
+func TestBenchmarkParse(t *testing.T) {
+	calls := 0
+	if _, err := benchmarkParse(7, func() error {
+		calls++
+		return nil
+	}); err != nil || calls != 7 {
+		t.Errorf("benchmarkParse(7) parsed %d times, %v, want 7 times", calls, err)
+	}
+
+	calls = 0
+	failure := errors.New("unreadable")
+	if _, err := benchmarkParse(7, func() error {
+		calls++
+		return failure
+	}); err != failure || calls != 1 {
+		t.Errorf("benchmarkParse with a failing parse = %v after %d calls, want the error after 1", err, calls)
+	}
+}
+
+func TestPrintARN(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n")
+
+	result := runMain(t, home, "", []string{"AWS_CLI_PATH=" + fakeCLI(t, callerIdentityScript)}, "-profile", "dev", "-print-arn")
+	if want := "arn:aws:iam::123456789012:user/dev\n"; result.code != 0 || result.stdout != want {
+		t.Errorf("exit code %d, stdout %q, want only %q", result.code, result.stdout, want)
+	}
+}
+
+func TestSearchProfilesMatchAllTerms(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"billing-prod": {Name: "billing-prod"},
+		"billing-dev":  {Name: "billing-dev"},
+		"search-prod":  {Name: "search-prod"},
+	}
+	tests := []struct {
+		matchAll bool
+		want     []string
+	}{
+		{true, []string{"billing-prod"}},
+		{false, []string{"billing-prod", "billing-dev", "search-prod"}},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		c.MatchAllTerms = tt.matchAll
+		setConfig(t, c)
+		if got := profileNames(searchProfiles(profiles, "billing prod")); !slices.Equal(got, tt.want) {
+			t.Errorf("match all terms %v: searchProfiles = %v, want %v", tt.matchAll, got, tt.want)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	const good = "[good]\naws_access_key_id = AKIAGOOD\naws_secret_access_key = s\nregion = us-east-1\n"
+	tests := []struct {
+		name        string
+		credentials string
+		env         []string
+		args        []string
+	}{
+		{"incomplete profile", good + "[broken]\nregion = us-east-1\n", nil, []string{"-list"}},
+		{"shared access key", good + "[copy]\naws_access_key_id = AKIAGOOD\naws_secret_access_key = s\n", nil, []string{"-list"}},
+		{"undefined source_profile", good + "[role]\nrole_arn = arn:aws:iam::1:role/r\nsource_profile = missing\n", nil, []string{"-list"}},
+		{"duplicate profile", good + good, nil, []string{"-list"}},
+		{"region variable conflict", good, []string{"AWS_REGION=eu-west-1"}, []string{"-region", "us-east-1", "-list"}},
+		{"unrecognized region", "[good]\naws_access_key_id = AKIAGOOD\naws_secret_access_key = s\nregion = moon-1\n", nil, []string{"-profile", "good", "-no-verify", "-no-last-save"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			writeFile(t, filepath.Join(home, ".aws", "credentials"), tt.credentials)
+
+			result := runMain(t, home, "", tt.env, tt.args...)
+			if result.code != 0 || !strings.Contains(result.stderr, "Warning: ") {
+				t.Errorf("without -strict: exit code %d, stderr %q, want a warning and success", result.code, result.stderr)
+			}
+			result = runMain(t, home, "", tt.env, append([]string{"-strict"}, tt.args...)...)
+			if result.code != exitError || !strings.Contains(result.stdout, "in strict mode") {
+				t.Errorf("with -strict: exit code %d, output %q, want the warning to fail the run", result.code, result.stdout)
+			}
+		})
+	}
+
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), good)
+	if result := runMain(t, home, "", nil, "-strict", "-list"); result.code != 0 || result.stderr != "" {
+		t.Errorf("no warnings with -strict: exit code %d, stderr %q, want success", result.code, result.stderr)
+	}
+}
+
+func TestProfileDetails(t *testing.T) {
+	c := defaultConfig()
+	c.EnvRegions = map[string]string{envProd: "us-east-1"}
+	setConfig(t, c)
+	profiles := map[string]AWSProfile{
+		"base":       {Name: "base", AWSAccountID: "111111111111", Region: "eu-west-1"},
+		"admin-prod": {Name: "admin-prod", RoleARN: "arn:aws:iam::222222222222:role/admin", SourceProfile: "middle"},
+		"middle":     {Name: "middle", RoleARN: "arn:aws:iam::333333333333:role/hop", SourceProfile: "base"},
+		"loop-test":  {Name: "loop-test", RoleARN: "arn:aws:iam::444444444444:role/loop", SourceProfile: "loop-test"},
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"base", "Account: 111111111111\nRegion: eu-west-1\nEnvironment: other\nRole: none"},
+		{"admin-prod", "Account: -\nRegion: us-east-1\nEnvironment: prod\nRole: arn:aws:iam::222222222222:role/admin via middle → base"},
+		{"loop-test", "Account: -\nRegion: Not set\nEnvironment: test\nRole: arn:aws:iam::444444444444:role/loop via loop-test"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := profileDetails(profiles, tt.name); got != tt.want {
+			t.Errorf("profileDetails(%s) =\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerificationRequired(t *testing.T) {
+	c := defaultConfig()
+	c.AlwaysVerify = []string{envProd}
+	setConfig(t, c)
+	tests := []struct {
+		profile string
+		verify  bool
+		want    bool
+	}{
+		{"billing-prod", false, true},
+		{"billing-prod", true, true},
+		{"billing-dev", false, false},
+		{"billing-dev", true, true},
+	}
+	for _, tt := range tests {
+		if got := verificationRequired(profileEnvironment(tt.profile), tt.verify); got != tt.want {
+			t.Errorf("verificationRequired(%s, verify %v) = %v, want %v", tt.profile, tt.verify, got, tt.want)
+		}
+	}
+}
+
+func TestAlwaysVerifyOverridesNoVerify(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[billing-prod]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n[billing-dev]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, callerIdentityScript), "AWS_PROFILE_SELECTOR_ALWAYS_VERIFY=prod"}
+
+	for profile, verified := range map[string]bool{"billing-prod": true, "billing-dev": false} {
+		result := runMain(t, home, "", env, "-profile", profile, "-no-verify", "-no-last-save")
+		if got := strings.Contains(result.stdout, "123456789012"); result.code != 0 || got != verified {
+			t.Errorf("%s with -no-verify: exit code %d, output %q, want verified %v", profile, result.code, result.stdout, verified)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n")
+	writeFile(t, filepath.Join(home, lastUsedFile), "other")
+	before := homeFiles(t, home)
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, callerIdentityScript)}
+
+	result := runMain(t, home, "", env, "-read-only", "-profile", "dev")
+	if result.code != 0 || !strings.Contains(result.stdout, "123456789012") {
+		t.Errorf("verified run: exit code %d, output %q", result.code, result.stdout)
+	}
+	if after := homeFiles(t, home); !maps.Equal(after, before) {
+		t.Errorf("files changed in read-only mode: %v, want %v", after, before)
+	}
+
+	for name := range mutatingFlags {
+		args := []string{"-read-only", "-" + name + "=dev"}
+		if name == "new" || name == "refresh-cache" || name == "region-only" {
+			args = []string{"-read-only", "-" + name}
+		}
+		result := runMain(t, home, "", env, args...)
+		if want := "-" + name + " is not allowed in read-only mode"; result.code != exitError || !strings.Contains(result.stdout, want) {
+			t.Errorf("%v: exit code %d, output %q, want %q", args, result.code, result.stdout, want)
+		}
+	}
+	if after := homeFiles(t, home); !maps.Equal(after, before) {
+		t.Errorf("files changed by rejected flags: %v, want %v", after, before)
+	}
+}
+
+func TestHomeDirOverride(t *testing.T) {
+	home := testHome(t)
+	t.Setenv("HOME", t.TempDir())
+	setConfig(t, defaultConfig())
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\nregion = us-east-1\n")
+
+	if got := homeDir(); got != home {
+		t.Errorf("homeDir() = %q, want %q", got, home)
+	}
+	profiles, err := loadProfiles(parseOptions{})
+	if err != nil || len(profiles) != 1 {
+		t.Errorf("loadProfiles = %v, %v, want the profile under %s", profiles, err, home)
+	}
+	if err := saveLastUsedProfile("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(home, lastUsedFile)); got != "dev" {
+		t.Errorf("saveLastUsedProfile wrote %q under %s, want dev", got, home)
+	}
+	writeFile(t, filepath.Join(home, lastUsedFile), "other\n")
+	if got := getLastUsedProfile(); got != "other" {
+		t.Errorf("getLastUsedProfile() = %q, want other from %s", got, home)
+	}
+	if entries, _ := os.ReadDir(os.Getenv("HOME")); len(entries) != 0 {
+		t.Errorf("files were written to $HOME: %v", entries)
+	}
+}
+
+func TestNoHomeDirectory(t *testing.T) {
+	t.Setenv("HOME", "")
+	t.Setenv("AWS_PROFILE_SELECTOR_HOME", "")
+	if dir, err := resolveHomeDir(); err == nil {
+		t.Errorf("resolveHomeDir() = %q without a home directory", dir)
+	}
+	t.Setenv("AWS_PROFILE_SELECTOR_HOME", "/srv/aws-login")
+	if dir, err := resolveHomeDir(); err != nil || dir != "/srv/aws-login" {
+		t.Errorf("resolveHomeDir() = %q, %v, want the override", dir, err)
+	}
+
+	result := runMain(t, t.TempDir(), "", []string{"HOME=", "AWS_PROFILE_SELECTOR_HOME="}, "-list")
+	want := "Error: no home directory: set $HOME, or $AWS_PROFILE_SELECTOR_HOME to the directory holding .aws/credentials\n"
+	if result.code != exitError || result.stdout != want {
+		t.Errorf("exit code %d, output %q, want %q", result.code, result.stdout+result.stderr, want)
+	}
+}
+
+func TestOrderProdLast(t *testing.T) {
+	profiles := []AWSProfile{{Name: "api-prod"}, {Name: "api-dev"}, {Name: "billing-prod"}, {Name: "api-test"}, {Name: "sandbox"}}
+	want := []string{"api-dev", "api-test", "sandbox", "api-prod", "billing-prod"}
+	if got := profileNames(orderProdLast(profiles)); !slices.Equal(got, want) {
+		t.Errorf("orderProdLast = %v, want %v", got, want)
+	}
+	if profiles[0].Name != "api-prod" {
+		t.Error("orderProdLast reordered its argument")
+	}
+}
+
+func TestExportQuoting(t *testing.T) {
+	tests := []struct {
+		profile AWSProfile
+		region  string
+		want    string
+	}{
+		{AWSProfile{Name: "dev"}, "us-east-1", "export AWS_PROFILE=dev AWS_REGION=us-east-1 AWS_DEFAULT_REGION=us-east-1"},
+		{AWSProfile{Name: "team dev"}, "", "export AWS_PROFILE='team dev'"},
+		{AWSProfile{Name: "bob's $HOME"}, "eu-west-1", `export AWS_PROFILE='bob'\''s $HOME' AWS_REGION=eu-west-1 AWS_DEFAULT_REGION=eu-west-1`},
+		{AWSProfile{Name: "dev;rm"}, "", "export AWS_PROFILE='dev;rm'"},
+		{AWSProfile{Name: ambientProfile}, "us-west-2", "unset AWS_PROFILE; export AWS_REGION=us-west-2 AWS_DEFAULT_REGION=us-west-2"},
+	}
+	for _, tt := range tests {
+		if got := exportLine(tt.profile, tt.region); got != tt.want {
+			t.Errorf("exportLine(%q, %q) = %q, want %q", tt.profile.Name, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyAllRegionsJSON(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n")
+	aws := fakeCLI(t, `case "$*" in
+*ap-east-1*) exit 255 ;;
+"sts get-caller-identity"*) echo '{"Account": "123456789012"}' ;;
+*) exit 1 ;;
+esac
+`)
+
+	result := runMain(t, home, "", []string{"AWS_CLI_PATH=" + aws, "AWS_PROFILE_SELECTOR_VERIFY_REGIONS=us-east-1,ap-east-1"}, "-profile", "dev", "-verify-all-regions", "-json", "-no-last-save")
+	if result.code != exitError {
+		t.Errorf("exit code %d, want %d", result.code, exitError)
+	}
+	var failure jsonError
+	if err := json.Unmarshal([]byte(result.stdout), &failure); err != nil || failure.Code != exitError {
+		t.Errorf("stdout %q is not a JSON error: %v", result.stdout, err)
+	}
+	if !strings.Contains(result.stderr, "ap-east-1 FAILED") {
+		t.Errorf("stderr %q lacks the region summary", result.stderr)
+	}
+}
+
+func TestAWSProfileWithoutArgs(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n\n[prod]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\nregion = us-east-1\n")
+	writeFile(t, filepath.Join(home, lastUsedFile), "prod")
+	env := []string{"AWS_PROFILE=dev", "AWS_CLI_PATH=" + fakeCLI(t, callerIdentityScript)}
+
+	result := runMain(t, home, "", env, "-export", "-explain")
+	if result.code != 0 {
+		t.Fatalf("exit code %d, output %q", result.code, result.stdout+result.stderr)
+	}
+	if want := "export AWS_PROFILE=dev AWS_REGION=eu-west-1 AWS_DEFAULT_REGION=eu-west-1\n"; result.stdout != want {
+		t.Errorf("-export printed %q, want %q", result.stdout, want)
+	}
+	if want := "Selected by: AWS_PROFILE (no terminal to prompt on)\n"; !strings.Contains(result.stderr, want) {
+		t.Errorf("trace %q doesn't contain %q", result.stderr, want)
+	}
+}
+
+func TestNoOnePass(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n")
+	log := filepath.Join(t.TempDir(), "commands")
+	aws := fakeCLI(t, "echo \"aws $*\" >> "+log+"\n"+callerIdentityScript)
+	op := fakeCLI(t, "echo \"op $*\" >> "+log+"\nshift 2\nexec \"$@\"\n")
+	env := []string{"USE_ONEPASS_CLI=true", "OP_CLI_PATH=" + op, "AWS_CLI_PATH=" + aws}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, "op run -- " + aws + " sts get-caller-identity"},
+		{[]string{"-no-onepass"}, "aws sts get-caller-identity"},
+	}
+	for _, tt := range tests {
+		if err := os.Remove(log); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		args := append([]string{"-profile", "dev", "-print-arn"}, tt.args...)
+		result := runMain(t, home, "", env, args...)
+		if result.code != 0 {
+			t.Fatalf("%v: exit code %d, output %q", tt.args, result.code, result.stdout+result.stderr)
+		}
+		commands := readFile(t, log)
+		if first, _, _ := strings.Cut(commands, "\n"); !strings.HasPrefix(first, tt.want) {
+			t.Errorf("%v: ran %q, want %q", tt.args, first, tt.want)
+		}
+		if onePass := strings.Contains(commands, "op run"); onePass != (tt.args == nil) {
+			t.Errorf("%v: commands went through op run: %v\n%s", tt.args, onePass, commands)
+		}
+	}
+}
+
+func TestStaleLastUsedProfile(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n")
+	writeFile(t, filepath.Join(home, lastUsedFile), "renamed-away")
+	log := filepath.Join(t.TempDir(), "commands")
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, "echo \"$*\" >> "+log+"\n"+callerIdentityScript)}
+
+	result := runMain(t, home, "", env, "-l")
+	if result.code != exitError {
+		t.Errorf("exit code %d, want %d", result.code, exitError)
+	}
+	if want := "Last used profile \"renamed-away\" no longer exists.\n"; result.stdout+result.stderr != want {
+		t.Errorf("output %q, want %q", result.stdout+result.stderr, want)
+	}
+	if commands := readFile(t, log); commands != "" {
+		t.Errorf("the stale profile was used:\n%s", commands)
+	}
+
+	result = runMain(t, home, "", env, "-l", "-json")
+	if want := "{\"error\":\"Last used profile \\\"renamed-away\\\" no longer exists.\",\"code\":1}\n"; result.stdout != want {
+		t.Errorf("-json printed %q, want %q", result.stdout, want)
+	}
+}
+
+func TestParseRawValues(t *testing.T) {
+	content := "[dev]\naws_access_key_id =   AKIA1\naws_secret_access_key = s1/trailing  \nregion = us-east-1\t\n"
+	tests := []struct {
+		opts                 parseOptions
+		accessKey, secretKey string
+		region               string
+	}{
+		{parseOptions{}, "AKIA1", "s1/trailing", "us-east-1"},
+		{parseOptions{RawValues: true}, "AKIA1", "s1/trailing  ", "us-east-1\t"},
+	}
+	for _, tt := range tests {
+		profile := parseAWSCredentials(content, tt.opts)["dev"]
+		if profile.AWSAccessKeyID != tt.accessKey || profile.AWSSecretAccessKey != tt.secretKey || profile.Region != tt.region {
+			t.Errorf("RawValues %v: got %q, %q, %q, want %q, %q, %q", tt.opts.RawValues,
+				profile.AWSAccessKeyID, profile.AWSSecretAccessKey, profile.Region, tt.accessKey, tt.secretKey, tt.region)
+		}
+	}
+
+	// Raw sections keep carriage returns, so merged files are byte-faithful.
+	path := filepath.Join(t.TempDir(), "credentials")
+	writeFile(t, path, "[dev]\r\naws_secret_access_key = s1 \r\n")
+	for raw, want := range map[bool]string{false: "aws_secret_access_key = s1 ", true: "aws_secret_access_key = s1 \r"} {
+		sections, err := readINISections(path, parseOptions{RawValues: raw})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sections[0].Entries[0].Lines[0]; got != want {
+			t.Errorf("RawValues %v: line %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestSelectKeyMap(t *testing.T) {
+	keyMap := selectKeyMap()
+	tests := []struct {
+		name    string
+		binding key.Binding
+		key     string
+		help    string
+	}{
+		{"up", keyMap.Select.Up, "k", "↑/k"},
+		{"up", keyMap.Select.Up, "up", "↑/k"},
+		{"down", keyMap.Select.Down, "j", "↓/j"},
+		{"down", keyMap.Select.Down, "down", "↓/j"},
+		{"filter", keyMap.Select.Filter, "/", "/"},
+	}
+	for _, tt := range tests {
+		if !tt.binding.Enabled() {
+			t.Errorf("%s is disabled", tt.name)
+		}
+		if !slices.Contains(tt.binding.Keys(), tt.key) {
+			t.Errorf("%s is bound to %v, want %q among them", tt.name, tt.binding.Keys(), tt.key)
+		}
+		if help := tt.binding.Help(); help.Key != tt.help || help.Desc != tt.name {
+			t.Errorf("%s help %q %q, want %q %q", tt.name, help.Key, help.Desc, tt.help, tt.name)
+		}
+	}
+}
+
+func TestProfilesFromStdin(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[from-file]\naws_access_key_id = AKIA0\naws_secret_access_key = s0\n")
+	stdin := "[stdin-dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n\n# Production\n[stdin-prod]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n"
+
+	result := runMain(t, home, stdin, nil, "-stdin", "-list")
+	if result.code != 0 {
+		t.Fatalf("exit code %d, output %q", result.code, result.stdout+result.stderr)
+	}
+	if want := "stdin-dev\nstdin-prod\n"; result.stdout != want {
+		t.Errorf("-stdin -list printed %q, want %q", result.stdout, want)
+	}
+
+	profiles, err := loadProfilesFromReader(strings.NewReader(stdin), parseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := profiles["stdin-prod"]; got.AWSAccessKeyID != "AKIA2" || got.Description != "Production" {
+		t.Errorf("stdin-prod parsed as %+v", got)
+	}
+
+	if result := runMain(t, home, "", nil, "-stdin", "-list"); result.code != exitNoProfiles {
+		t.Errorf("empty stdin: exit code %d, want %d", result.code, exitNoProfiles)
+	}
+}
+
+func TestWhichJSON(t *testing.T) {
+	credentials := "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n\n[prod]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\nregion = us-east-1\n"
+	tests := []struct {
+		name     string
+		env      []string
+		lastUsed string
+		want     whichResult
+	}{
+		{"env", []string{"AWS_PROFILE=dev"}, "prod", whichResult{Profile: "dev", Region: "eu-west-1", Source: profileSourceEnv}},
+		{"state", nil, "prod", whichResult{Profile: "prod", Region: "us-east-1", Source: profileSourceState}},
+		{"none", nil, "", whichResult{Source: profileSourceNone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			writeFile(t, filepath.Join(home, ".aws", "credentials"), credentials)
+			if tt.lastUsed != "" {
+				writeFile(t, filepath.Join(home, lastUsedFile), tt.lastUsed)
+			}
+			result := runMain(t, home, "", tt.env, "-which", "-json")
+			if result.code != 0 {
+				t.Fatalf("exit code %d, output %q", result.code, result.stdout+result.stderr)
+			}
+			var got whichResult
+			if err := json.Unmarshal([]byte(result.stdout), &got); err != nil {
+				t.Fatalf("stdout %q isn't JSON: %v", result.stdout, err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestElideName(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		want string
+	}{
+		{"dev", 12, "dev"},
+		{"abcdefghij", 10, "abcdefghij"},
+		{"abcdefghij", 5, "ab…ij"},
+		{"abcdefghij", 6, "abc…ij"},
+		{"team-a-123456789012-AdministratorAccess", 20, "team-a-123…torAccess"},
+		{"日本語テスト", 4, "日本…ト"},
+		{"abcdefghij", 1, "…"},
+		{"abcdefghij", 0, ""},
+	}
+	for _, tt := range tests {
+		got := elideName(tt.name, tt.max)
+		if got != tt.want {
+			t.Errorf("elideName(%q, %d) = %q, want %q", tt.name, tt.max, got, tt.want)
+		}
+		if n := len([]rune(got)); n > max(tt.max, 0) {
+			t.Errorf("elideName(%q, %d) is %d characters long", tt.name, tt.max, n)
+		}
+	}
+}
+
+func TestTopScoreTies(t *testing.T) {
+	ranked := []AWSProfile{{Name: "api-east"}, {Name: "api-west"}, {Name: "web-api"}}
+	tests := []struct {
+		scores []int
+		want   []string
+	}{
+		{[]int{90, 50, 10}, []string{"api-east"}},
+		{[]int{90, 90, 10}, []string{"api-east", "api-west"}},
+		{[]int{90, 90, 90}, []string{"api-east", "api-west", "web-api"}},
+	}
+	for _, tt := range tests {
+		if got := topScoreTies(ranked, tt.scores); !slices.Equal(got, tt.want) {
+			t.Errorf("topScoreTies(%v) = %v, want %v", tt.scores, got, tt.want)
+		}
+	}
+	if got := topScoreTies(nil, nil); got != nil {
+		t.Errorf("topScoreTies of no matches = %v", got)
+	}
+
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[api-east]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n\n[api-west]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	result := runMain(t, home, "y\n", nil, "-s", "api", "-fail-on-ambiguous", "-no-verify", "-no-last-save")
+	if want := "\"api\" matches several profiles equally well: api-east, api-west"; result.code != exitError || !strings.Contains(result.stdout+result.stderr, want) {
+		t.Errorf("ambiguous: exit code %d, output %q, want %q", result.code, result.stdout+result.stderr, want)
+	}
+	result = runMain(t, home, "y\n", nil, "-s", "west", "-fail-on-ambiguous", "-no-verify", "-no-last-save", "-export")
+	if result.code != 0 || !strings.HasPrefix(result.stdout, "export AWS_PROFILE=api-west") {
+		t.Errorf("unambiguous: exit code %d, output %q", result.code, result.stdout+result.stderr)
+	}
+}
+
+func TestGrep(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[prod-api]\n[prod-web]\n[dev-prod-mirror]\n[sandbox]\n")
+	tests := []struct {
+		pattern string
+		args    []string
+		code    int
+		stdout  string
+		stderr  string
+	}{
+		{"^prod-", nil, 0, "prod-api\nprod-web\n", ""},
+		{"prod", nil, 0, "dev-prod-mirror\nprod-api\nprod-web\n", ""},
+		{"^staging", nil, exitError, "", "No profiles match \"^staging\"\n"},
+		{"^staging", []string{"-json"}, exitError, "{\"error\":\"No profiles match \\\"^staging\\\"\",\"code\":1}\n", ""},
+		{"prod-(", nil, exitError, "", "Invalid -grep pattern \"prod-(\": error parsing regexp: missing closing ): `prod-(`\n"},
+		{"prod-(", []string{"-json"}, exitError, "{\"error\":\"Invalid -grep pattern \\\"prod-(\\\": error parsing regexp: missing closing ): `prod-(`\",\"code\":1}\n", ""},
+	}
+	for _, tt := range tests {
+		result := runMain(t, home, "", nil, append([]string{"-grep", tt.pattern}, tt.args...)...)
+		// Warnings about the credential-less profiles precede any error.
+		if result.code != tt.code || result.stdout != tt.stdout || !strings.HasSuffix(result.stderr, tt.stderr) {
+			t.Errorf("-grep %q %v: exit code %d, stdout %q, stderr %q, want %d, %q, %q", tt.pattern, tt.args, result.code, result.stdout, result.stderr, tt.code, tt.stdout, tt.stderr)
+		}
+	}
+}
+
+func TestChainOptions(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"admin":    {Name: "admin", RoleARN: "arn:aws:iam::333333333333:role/admin", SourceProfile: "ops"},
+		"ops":      {Name: "ops", RoleARN: "arn:aws:iam::222222222222:role/ops", SourceProfile: "base"},
+		"base":     {Name: "base", AWSAccessKeyID: "AKIA1"},
+		"orphan":   {Name: "orphan", RoleARN: "arn:aws:iam::444444444444:role/orphan", SourceProfile: "missing"},
+		"loop-a":   {Name: "loop-a", SourceProfile: "loop-b"},
+		"loop-b":   {Name: "loop-b", SourceProfile: "loop-a"},
+		"solitary": {Name: "solitary", AWSAccessKeyID: "AKIA2"},
+	}
+	tests := []struct {
+		name   string
+		chain  []string
+		labels []string
+	}{
+		{"admin", []string{"ops", "base"}, []string{
+			"admin (assumes arn:aws:iam::333333333333:role/admin)",
+			"ops (assumes arn:aws:iam::222222222222:role/ops)",
+			"base",
+		}},
+		{"orphan", []string{"missing"}, []string{"orphan (assumes arn:aws:iam::444444444444:role/orphan)"}},
+		{"loop-a", []string{"loop-b", "loop-a"}, []string{"loop-a", "loop-b"}},
+		{"solitary", nil, []string{"solitary"}},
+	}
+	for _, tt := range tests {
+		if got := sourceChain(profiles, tt.name); !slices.Equal(got, tt.chain) {
+			t.Errorf("sourceChain(%s) = %v, want %v", tt.name, got, tt.chain)
+		}
+		options := chainOptions(profiles, tt.name)
+		var labels, values []string
+		for _, option := range options {
+			labels = append(labels, option.Key)
+			values = append(values, option.Value)
+		}
+		if !slices.Equal(labels, tt.labels) {
+			t.Errorf("chainOptions(%s) labels %q, want %q", tt.name, labels, tt.labels)
+		}
+		if len(values) == 0 || values[0] != tt.name {
+			t.Errorf("chainOptions(%s) values %v don't start with the profile itself", tt.name, values)
+		}
+	}
+}
+
+func TestParseExpandEnv(t *testing.T) {
+	t.Setenv("AWS_LOGIN_TEST_KEY_ID", "AKIA9")
+	t.Setenv("AWS_LOGIN_TEST_REGION", "eu-west-1")
+	content := "[dev]\naws_access_key_id = $AWS_LOGIN_TEST_KEY_ID\naws_secret_access_key = ${AWS_LOGIN_TEST_UNSET}\nregion = ${AWS_LOGIN_TEST_REGION}\nrole_arn = arn:${AWS_LOGIN_TEST_UNSET}:x\n"
+
+	for _, expand := range []bool{false, true} {
+		parser := newCredentialsParser(parseOptions{ExpandEnv: expand})
+		for _, line := range strings.Split(content, "\n") {
+			parser.parseLine(line)
+		}
+		got := parser.profiles["dev"]
+		want := AWSProfile{Name: "dev", AWSAccessKeyID: "$AWS_LOGIN_TEST_KEY_ID", AWSSecretAccessKey: "${AWS_LOGIN_TEST_UNSET}", Region: "${AWS_LOGIN_TEST_REGION}", RoleARN: "arn:${AWS_LOGIN_TEST_UNSET}:x"}
+		var wantUnset []string
+		if expand {
+			want = AWSProfile{Name: "dev", AWSAccessKeyID: "AKIA9", Region: "eu-west-1", RoleARN: "arn::x"}
+			wantUnset = []string{"AWS_LOGIN_TEST_UNSET"}
+		}
+		if got != want {
+			t.Errorf("ExpandEnv %v: got %+v, want %+v", expand, got, want)
+		}
+		if !slices.Equal(parser.unsetVars, wantUnset) {
+			t.Errorf("ExpandEnv %v: unset variables %v, want %v", expand, parser.unsetVars, wantUnset)
+		}
+	}
+
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), content)
+	result := runMain(t, home, "", []string{"AWS_LOGIN_TEST_REGION=eu-west-1"}, "-expand-env", "-profile", "dev", "-print-region")
+	if result.stdout != "eu-west-1\n" {
+		t.Errorf("-expand-env -print-region printed %q", result.stdout)
+	}
+	if want := "environment variable AWS_LOGIN_TEST_UNSET is not set; it was expanded to an empty string"; strings.Count(result.stderr, want) != 1 {
+		t.Errorf("stderr %q doesn't warn once about AWS_LOGIN_TEST_UNSET", result.stderr)
+	}
+}
+
+func TestNoPrompt(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n\n[prod]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	wantError := "Error: " + errNoPrompt.Error() + "\n"
+
+	// The y answers the -s suggestion, which -no-prompt mustn't ask for.
+	for _, args := range [][]string{nil, {"-i"}, {"-s", "zzz", "-no-last-save"}, {"-s", "dev", "-no-last-save"}} {
+		result := runMain(t, home, "y\n", nil, append(args, "-no-prompt", "-no-verify")...)
+		if result.code != exitError || !strings.HasSuffix(result.stdout, wantError) {
+			t.Errorf("%v: exit code %d, output %q, want %q", args, result.code, result.stdout+result.stderr, wantError)
+		}
+	}
+
+	result := runMain(t, home, "", nil, "-no-prompt", "-no-verify", "-json")
+	var got jsonError
+	if err := json.Unmarshal([]byte(result.stdout), &got); err != nil || got.Code != exitError || got.Error != "Error: "+errNoPrompt.Error() {
+		t.Errorf("-json: %q, %v", result.stdout, err)
+	}
+
+	// A profile chosen without a prompt is fine.
+	if result := runMain(t, home, "", nil, "-no-prompt", "-no-verify", "-profile", "prod"); result.code != 0 {
+		t.Errorf("-profile: exit code %d, output %q", result.code, result.stdout+result.stderr)
+	}
+}
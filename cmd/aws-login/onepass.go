@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func onePassWhoamiCommand() *exec.Cmd {
+	return exec.Command(cfg.OPCLIPath, "whoami")
+}
+
+// checkOnePass checks that the 1Password CLI is installed and signed in, and
+// returns the account it is signed in to as printed by `op whoami`. The
+// errors say how to fix the problem.
+func checkOnePass() (string, error) {
+	if _, err := exec.LookPath(cfg.OPCLIPath); err != nil {
+		return "", fmt.Errorf("1Password CLI not found at %q: install it from https://developer.1password.com/docs/cli or set OP_CLI_PATH", cfg.OPCLIPath)
+	}
+	output, err := onePassWhoamiCommand().CombinedOutput()
+	if err != nil {
+		reason, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+		if reason == "" {
+			reason = err.Error()
+		}
+		return "", fmt.Errorf("1Password CLI is not signed in (%s): run `eval $(%s signin)` or turn on the 1Password app integration", reason, cfg.OPCLIPath)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckOnePass(t *testing.T) {
+	tests := []struct {
+		name    string
+		opPath  string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "signed in",
+			opPath: fakeCLI(t, "echo 'URL:        https://my.1password.com/'\necho 'Email:      dev@example.com'\n"),
+			want:   "URL:        https://my.1password.com/\nEmail:      dev@example.com",
+		},
+		{
+			name:    "signed out",
+			opPath:  fakeCLI(t, "echo '[ERROR] 2024/05/01 12:00:00 account is not signed in' >&2\nexit 1\n"),
+			wantErr: "1Password CLI is not signed in ([ERROR] 2024/05/01 12:00:00 account is not signed in): run `eval $(",
+		},
+		{
+			name:    "signed out without output",
+			opPath:  fakeCLI(t, "exit 1\n"),
+			wantErr: "1Password CLI is not signed in (exit status 1)",
+		},
+		{
+			name:    "missing",
+			opPath:  filepath.Join(t.TempDir(), "op"),
+			wantErr: "1Password CLI not found at",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			c.OPCLIPath = tt.opPath
+			setConfig(t, c)
+
+			got, err := checkOnePass()
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("error %v, want one starting with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("checkOnePass() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
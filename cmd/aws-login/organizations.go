@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const accountNamesFile = ".aws-profile-selector-accounts.json"
+
+// accountNamesTTL is how long the account names fetched from Organizations
+// are reused before they are fetched again.
+const accountNamesTTL = 24 * time.Hour
+
+// accountNames maps account ids to the names shown next to them in the
+// prompt. It is empty unless account name lookup is enabled.
+var accountNames map[string]string
+
+// listAccountsResponse is the response of `aws organizations list-accounts`.
+type listAccountsResponse struct {
+	Accounts []struct {
+		ID   string `json:"Id"`
+		Name string `json:"Name"`
+	} `json:"Accounts"`
+}
+
+func accountNamesFilePath() string {
+	return filepath.Join(homeDir(), accountNamesFile)
+}
+
+// loadAccountNames returns the account names of the organization, from the
+// cache if it is younger than accountNamesTTL and otherwise by listing the
+// accounts as profileName. Callers fall back to showing bare account ids
+// when it fails, e.g. because the profile may not call Organizations.
+func loadAccountNames(profileName string) (map[string]string, error) {
+	path := accountNamesFilePath()
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < accountNamesTTL {
+		content, err := os.ReadFile(path)
+		if err == nil {
+			var names map[string]string
+			if json.Unmarshal(content, &names) == nil {
+				return names, nil
+			}
+		}
+	}
+
+	output, err := listAccountsCommand(profileName).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing accounts: %v", err)
+	}
+	names, err := parseAccountNames(output)
+	if err != nil {
+		return nil, err
+	}
+	if content, err := json.Marshal(names); err == nil && !cfg.ReadOnly {
+		os.WriteFile(path, content, 0644)
+	}
+	return names, nil
+}
+
+func listAccountsCommand(profileName string) *exec.Cmd {
+	return awsCommand(profileName, "organizations", "list-accounts", "--output", "json")
+}
+
+// parseAccountNames maps the account ids in a list-accounts response to
+// their names.
+func parseAccountNames(output []byte) (map[string]string, error) {
+	var response listAccountsResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("error parsing list-accounts response: %v", err)
+	}
+	names := make(map[string]string)
+	for _, account := range response.Accounts {
+		if account.ID != "" && account.Name != "" {
+			names[account.ID] = account.Name
+		}
+	}
+	return names, nil
+}
+
+// accountLabel returns "name (id)" for an account with a known name and the
+// bare id otherwise.
+func accountLabel(accountID string, names map[string]string) string {
+	if name, ok := names[accountID]; ok && accountID != "" {
+		return fmt.Sprintf("%s (%s)", name, accountID)
+	}
+	return accountID
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestAccountNames(t *testing.T) {
+	testHome(t)
+	c := defaultConfig()
+	c.AWSCLIPath = fakeCLI(t, `echo '{"Accounts": [{"Id": "111111111111", "Name": "Billing"}, {"Id": "222222222222", "Name": ""}]}'
+`)
+	setConfig(t, c)
+
+	names, err := loadAccountNames("org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"111111111111": "Billing"}; !maps.Equal(names, want) {
+		t.Errorf("account names %v, want %v", names, want)
+	}
+	if got := accountLabel("111111111111", names); got != "Billing (111111111111)" {
+		t.Errorf("label of a named account %q", got)
+	}
+	if got := accountLabel("222222222222", names); got != "222222222222" {
+		t.Errorf("label of an unnamed account %q, want the bare id", got)
+	}
+
+	// The cached names are used while Organizations can't be reached.
+	cfg.AWSCLIPath = fakeCLI(t, "exit 255\n")
+	if cached, err := loadAccountNames("org"); err != nil || !maps.Equal(cached, names) {
+		t.Errorf("cached account names %v, %v, want %v", cached, err, names)
+	}
+}
+
+func TestAccountNamesFallback(t *testing.T) {
+	testHome(t)
+	c := defaultConfig()
+	c.AWSCLIPath = fakeCLI(t, "echo 'AccessDenied' >&2\nexit 254\n")
+	setConfig(t, c)
+
+	names, err := loadAccountNames("org")
+	if err == nil {
+		t.Errorf("got names %v, want an error", names)
+	}
+	if got := accountLabel("111111111111", names); got != "111111111111" {
+		t.Errorf("label without names %q, want the bare id", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// rankCommand builds the external ranking command configured with
+// rank_command, split on whitespace.
+func rankCommand(command string) (*exec.Cmd, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("rank command is empty")
+	}
+	return exec.Command(fields[0], fields[1:]...), nil
+}
+
+// externalRank ranks profiles for query with the external command. The
+// command reads the query on the first line of stdin followed by the
+// profile names, one per line and sorted, and writes the names to suggest,
+// best first. Names it writes that aren't profiles are ignored.
+func externalRank(command string, profiles map[string]AWSProfile, query string) ([]AWSProfile, error) {
+	cmd, err := rankCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cmd.Stdin = strings.NewReader(query + "\n" + strings.Join(names, "\n") + "\n")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("rank command %q: %v", command, err)
+	}
+	return parseRankOutput(output, profiles), nil
+}
+
+// parseRankOutput returns the profiles named by the lines of output, in
+// order, skipping unknown names and repeats. It is never nil, so that a
+// command suggesting nothing isn't mistaken for one that failed.
+func parseRankOutput(output []byte, profiles map[string]AWSProfile) []AWSProfile {
+	ranked := []AWSProfile{}
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		profile, ok := profiles[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		ranked = append(ranked, profile)
+	}
+	return ranked
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExternalRank(t *testing.T) {
+	profiles := map[string]AWSProfile{"api-dev": {Name: "api-dev"}, "api-prod": {Name: "api-prod"}, "sandbox": {Name: "sandbox"}}
+	input := filepath.Join(t.TempDir(), "input")
+
+	tests := []struct {
+		name    string
+		script  string
+		want    []string
+		wantErr bool
+	}{
+		{"ranked", "cat > " + input + "\nprintf 'api-prod\\nunknown\\napi-dev\\napi-prod\\n'\n", []string{"api-prod", "api-dev"}, false},
+		{"no suggestions", "cat > /dev/null\n", []string{}, false},
+		{"failed", "exit 3\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked, err := externalRank(fakeCLI(t, tt.script)+" --flag", profiles, "api")
+			if tt.wantErr {
+				if err == nil || ranked != nil {
+					t.Errorf("externalRank = %v, %v, want an error to fall back on", ranked, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Never nil, so that no suggestions isn't taken for a failure.
+			if ranked == nil || !slices.Equal(profileNames(ranked), tt.want) {
+				t.Errorf("externalRank = %#v, want %v", ranked, tt.want)
+			}
+		})
+	}
+	if got, want := readFile(t, input), "api\napi-dev\napi-prod\nsandbox\n"; got != want {
+		t.Errorf("the command read %q, want %q", got, want)
+	}
+
+	if _, err := externalRank("  ", profiles, "api"); err == nil {
+		t.Error("an empty command succeeded")
+	}
+}
+
+func TestRankCommandFallback(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[api-dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n\n[sandbox]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	args := []string{"-s", "api", "-explain", "-no-verify", "-no-last-save", "-export"}
+
+	ranker := fakeCLI(t, "cat > /dev/null\necho sandbox\n")
+	result := runMain(t, home, "y\n", []string{"AWS_PROFILE_SELECTOR_RANK_CMD=" + ranker}, args...)
+	if !strings.HasPrefix(result.stdout, "export AWS_PROFILE=sandbox") || !strings.Contains(result.stderr, "rank command placed sandbox at 1") {
+		t.Errorf("with a rank command: stdout %q, stderr %q", result.stdout, result.stderr)
+	}
+
+	result = runMain(t, home, "y\n", []string{"AWS_PROFILE_SELECTOR_RANK_CMD=" + fakeCLI(t, "exit 1\n")}, args...)
+	if !strings.HasPrefix(result.stdout, "export AWS_PROFILE=api-dev") || !strings.Contains(result.stderr, "using the built-in ranking") || !strings.Contains(result.stderr, ": api-dev\n") {
+		t.Errorf("with a failing rank command: stdout %q, stderr %q", result.stdout, result.stderr)
+	}
+}
+
+func TestParseRankOutput(t *testing.T) {
+	profiles := map[string]AWSProfile{"api-dev": {Name: "api-dev"}, "api-prod": {Name: "api-prod"}}
+	tests := []struct {
+		output string
+		want   []string
+	}{
+		{"api-prod\napi-dev\n", []string{"api-prod", "api-dev"}},
+		{"  api-dev  \r\nunknown\napi-dev\n\napi-prod", []string{"api-dev", "api-prod"}},
+		{"unknown\n", []string{}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		ranked := parseRankOutput([]byte(tt.output), profiles)
+		if ranked == nil {
+			t.Errorf("parseRankOutput(%q) is nil", tt.output)
+		}
+		if got := profileNames(ranked); !slices.Equal(got, tt.want) {
+			t.Errorf("parseRankOutput(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// knownRegions lists the AWS regions recognized by validateRegion.
+var knownRegions = []string{
+	"af-south-1",
+	"ap-east-1",
+	"ap-northeast-1",
+	"ap-northeast-2",
+	"ap-northeast-3",
+	"ap-south-1",
+	"ap-south-2",
+	"ap-southeast-1",
+	"ap-southeast-2",
+	"ap-southeast-3",
+	"ap-southeast-4",
+	"ap-southeast-5",
+	"ca-central-1",
+	"ca-west-1",
+	"cn-north-1",
+	"cn-northwest-1",
+	"eu-central-1",
+	"eu-central-2",
+	"eu-north-1",
+	"eu-south-1",
+	"eu-south-2",
+	"eu-west-1",
+	"eu-west-2",
+	"eu-west-3",
+	"il-central-1",
+	"me-central-1",
+	"me-south-1",
+	"sa-east-1",
+	"us-east-1",
+	"us-east-2",
+	"us-gov-east-1",
+	"us-gov-west-1",
+	"us-west-1",
+	"us-west-2",
+}
+
+// regionNames maps the codes of knownRegions to the names AWS gives them.
+var regionNames = map[string]string{
+	"af-south-1":     "Africa (Cape Town)",
+	"ap-east-1":      "Asia Pacific (Hong Kong)",
+	"ap-northeast-1": "Asia Pacific (Tokyo)",
+	"ap-northeast-2": "Asia Pacific (Seoul)",
+	"ap-northeast-3": "Asia Pacific (Osaka)",
+	"ap-south-1":     "Asia Pacific (Mumbai)",
+	"ap-south-2":     "Asia Pacific (Hyderabad)",
+	"ap-southeast-1": "Asia Pacific (Singapore)",
+	"ap-southeast-2": "Asia Pacific (Sydney)",
+	"ap-southeast-3": "Asia Pacific (Jakarta)",
+	"ap-southeast-4": "Asia Pacific (Melbourne)",
+	"ap-southeast-5": "Asia Pacific (Malaysia)",
+	"ca-central-1":   "Canada (Central)",
+	"ca-west-1":      "Canada West (Calgary)",
+	"cn-north-1":     "China (Beijing)",
+	"cn-northwest-1": "China (Ningxia)",
+	"eu-central-1":   "Europe (Frankfurt)",
+	"eu-central-2":   "Europe (Zurich)",
+	"eu-north-1":     "Europe (Stockholm)",
+	"eu-south-1":     "Europe (Milan)",
+	"eu-south-2":     "Europe (Spain)",
+	"eu-west-1":      "Europe (Ireland)",
+	"eu-west-2":      "Europe (London)",
+	"eu-west-3":      "Europe (Paris)",
+	"il-central-1":   "Israel (Tel Aviv)",
+	"me-central-1":   "Middle East (UAE)",
+	"me-south-1":     "Middle East (Bahrain)",
+	"sa-east-1":      "South America (São Paulo)",
+	"us-east-1":      "US East (N. Virginia)",
+	"us-east-2":      "US East (Ohio)",
+	"us-gov-east-1":  "AWS GovCloud (US-East)",
+	"us-gov-west-1":  "AWS GovCloud (US-West)",
+	"us-west-1":      "US West (N. California)",
+	"us-west-2":      "US West (Oregon)",
+}
+
+// regionSearchWeights ranks regions for searchRegions. Unlike profile
+// names, region names are worth matching loosely, e.g. "frnk" for Frankfurt.
+var regionSearchWeights = rankWeights{Substring: 2, Prefix: 1, Subsequence: 1}
+
+// searchRegions returns the known regions whose code or name matches every
+// term of query, best match first, or all of them for an empty query.
+func searchRegions(query string) []string {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return knownRegions
+	}
+
+	scores := make(map[string]int)
+	var matches []string
+	for _, code := range knownRegions {
+		name := strings.ToLower(regionNames[code])
+		score := 0
+		for _, term := range terms {
+			termScore := max(nameScore(code, term, regionSearchWeights), nameScore(name, term, regionSearchWeights))
+			if termScore == 0 {
+				score = 0
+				break
+			}
+			score += termScore
+		}
+		if score > 0 {
+			scores[code] = score
+			matches = append(matches, code)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return scores[matches[i]] > scores[matches[j]]
+	})
+	return matches
+}
+
+var regionFormatRegexp = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]*)?-[a-z]+-[0-9]+$`)
+
+// missingRegionDashRegexp matches a region missing the dash before its
+// number, e.g. "us-east1".
+var missingRegionDashRegexp = regexp.MustCompile(`^([a-z]{2}(?:-gov)?-[a-z]+)([0-9]+)$`)
+
+// validateRegion normalizes region (trimming, lowercasing and restoring a
+// missing dash before the number) and reports whether the result is a
+// recognized AWS region: one in knownRegions, or one shaped like a region so
+// that regions launched after this list was written are still accepted.
+func validateRegion(region string) (string, bool) {
+	normalized := strings.ToLower(strings.TrimSpace(region))
+	if normalized == "" {
+		return "", false
+	}
+	if match := missingRegionDashRegexp.FindStringSubmatch(normalized); match != nil {
+		normalized = match[1] + "-" + match[2]
+	}
+
+	for _, known := range knownRegions {
+		if normalized == known {
+			return normalized, true
+		}
+	}
+	return normalized, regionFormatRegexp.MatchString(normalized)
+}
+
+// regionFlag is the value of -region, which overrides every other source.
+var regionFlag string
+
+// regionEnvVars are the environment variables the AWS CLI and SDKs read
+// the region from.
+var regionEnvVars = []string{"AWS_REGION", "AWS_DEFAULT_REGION"}
+
+// regionEnvConflict reports whether the environment variable envVar, set to
+// envRegion, names a different region than -region, with a message saying
+// which one wins.
+func regionEnvConflict(flagRegion, envVar, envRegion string) (bool, string) {
+	if flagRegion == "" || envRegion == "" {
+		return false, ""
+	}
+	flagNormalized, _ := validateRegion(flagRegion)
+	envNormalized, _ := validateRegion(envRegion)
+	if flagNormalized == envNormalized {
+		return false, ""
+	}
+	return true, fmt.Sprintf("%s is %s but -region is %s; -region wins", envVar, envNormalized, flagNormalized)
+}
+
+// envDefaultRegion returns the configured default region for an environment
+// class, or "" if there is none.
+func envDefaultRegion(env string) string {
+	return cfg.EnvRegions[env]
+}
+
+// regionMismatch reports whether the region the profile declares differs
+// from configured, the region `aws configure get region` returns for it,
+// with a message naming both.
+func regionMismatch(profile AWSProfile, configured string) (bool, string) {
+	if profile.Region == "" || configured == "" {
+		return false, ""
+	}
+	declared, _ := validateRegion(profile.Region)
+	if declared == configured {
+		return false, ""
+	}
+	return true, fmt.Sprintf("profile %s declares region %s but the AWS CLI is configured with %s", profile.Name, declared, configured)
+}
+
+// resolveRegion returns the region the profile will use, in order of
+// precedence: -region, the region remembered with -region-only, the
+// profile's own region setting, the default region of the profile's
+// environment, and finally the AWS CLI's configured region.
+func resolveRegion(profile AWSProfile) string {
+	region, _ := resolveRegionSource(profile)
+	return region
+}
+
+// resolveRegionSource is resolveRegion, also describing where the region
+// came from.
+func resolveRegionSource(profile AWSProfile) (string, string) {
+	if regionFlag != "" {
+		region, ok := validateRegion(regionFlag)
+		if !ok {
+			warn("unrecognized region %q", regionFlag)
+		}
+		return region, "-region"
+	}
+	if region, ok := getRememberedRegions()[profile.Name]; ok {
+		return region, "-region-only"
+	}
+	if profile.Region != "" {
+		region, ok := validateRegion(profile.Region)
+		if !ok {
+			warn("unrecognized region %q in profile %s", profile.Region, profile.Name)
+		}
+		return region, "profile"
+	}
+	env := profileEnvironment(profile.Name)
+	if region := envDefaultRegion(env); region != "" {
+		return region, "default for " + env + " profiles"
+	}
+	return getCurrentRegion(profile.Name), "aws configure get region"
+}
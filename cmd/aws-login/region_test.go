@@ -0,0 +1,240 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		want   string
+		ok     bool
+	}{
+		{"us-east-1", "us-east-1", true},
+		{" EU-West-2 ", "eu-west-2", true},
+		{"us-gov-west-1", "us-gov-west-1", true},
+		{"us-east1", "us-east-1", true},
+		{"ap-newplace-9", "ap-newplace-9", true},
+		{"us-east", "us-east", false},
+		{"useast1", "useast1", false},
+		{"virginia", "virginia", false},
+		{"", "", false},
+		{"   ", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := validateRegion(tt.region)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("validateRegion(%q) = %q, %v, want %q, %v", tt.region, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRegionResolvedOnce(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n[typo]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\nregion = bogus\n")
+	log := filepath.Join(t.TempDir(), "calls")
+	aws := fakeCLI(t, `echo "$AWS_PROFILE $*" >> `+log+`
+case "$1 $2" in
+"configure get") [ "$AWS_PROFILE" = dev ] && echo eu-west-1 ;;
+*) exit 1 ;;
+esac
+`)
+	env := []string{"AWS_CLI_PATH=" + aws}
+
+	result := runMain(t, home, "", env, "-profile", "dev", "-no-verify", "-no-last-save", "-explain", "-export")
+	if result.code != 0 || !strings.Contains(result.stdout, "eu-west-1") {
+		t.Errorf("exit code %d, stdout %q, want the region configured for dev", result.code, result.stdout)
+	}
+	if calls := readFile(t, log); calls != "dev configure get region\n" {
+		t.Errorf("AWS CLI calls %q, want one region lookup for dev", calls)
+	}
+
+	result = runMain(t, home, "", env, "-profile", "typo", "-no-verify", "-no-last-save", "-explain", "-export")
+	if n := strings.Count(result.stderr, `unrecognized region "bogus"`); n != 1 {
+		t.Errorf("stderr %q warns %d times about the region, want once", result.stderr, n)
+	}
+}
+
+func TestEnvironmentDefaultRegion(t *testing.T) {
+	testHome(t)
+	c := defaultConfig()
+	c.AWSCLIPath = fakeCLI(t, "echo ap-southeast-2\n")
+	c.EnvRegions = map[string]string{envProd: "us-east-1", envTest: "us-west-2"}
+	setConfig(t, c)
+
+	tests := []struct {
+		profile AWSProfile
+		want    string
+	}{
+		{AWSProfile{Name: "billing-prod"}, "us-east-1"},
+		{AWSProfile{Name: "billing-test"}, "us-west-2"},
+		{AWSProfile{Name: "sandbox"}, "ap-southeast-2"},
+		{AWSProfile{Name: "billing-prod", Region: "eu-west-1"}, "eu-west-1"},
+	}
+	for _, tt := range tests {
+		if got := resolveRegion(tt.profile); got != tt.want {
+			t.Errorf("resolveRegion(%+v) = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestRegionMismatch(t *testing.T) {
+	tests := []struct {
+		profile    AWSProfile
+		configured string
+		want       bool
+	}{
+		{AWSProfile{Name: "dev", Region: "us-east-1"}, "us-east-1", false},
+		{AWSProfile{Name: "dev", Region: "US-East-1 "}, "us-east-1", false},
+		{AWSProfile{Name: "dev", Region: "us-east-1"}, "eu-west-1", true},
+		{AWSProfile{Name: "dev"}, "eu-west-1", false},
+		{AWSProfile{Name: "dev", Region: "us-east-1"}, "", false},
+	}
+	for _, tt := range tests {
+		mismatch, message := regionMismatch(tt.profile, tt.configured)
+		if mismatch != tt.want {
+			t.Errorf("regionMismatch(%q, %q) = %v, want %v", tt.profile.Region, tt.configured, mismatch, tt.want)
+		}
+		if want := "profile dev declares region us-east-1 but the AWS CLI is configured with eu-west-1"; mismatch && message != want {
+			t.Errorf("message %q, want %q", message, want)
+		}
+	}
+
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n")
+	aws := fakeCLI(t, `case "$1 $2" in
+"configure get") [ "$AWS_PROFILE" = dev ] && echo us-east-1 ;;
+*) exit 1 ;;
+esac
+`)
+	result := runMain(t, home, "", []string{"AWS_CLI_PATH=" + aws}, "-profile", "dev", "-no-verify", "-no-last-save", "-verbose")
+	if want := "Region mismatch: profile dev declares region eu-west-1 but the AWS CLI is configured with us-east-1\n"; !strings.Contains(result.stdout, want) {
+		t.Errorf("stdout %q doesn't report the mismatch %q", result.stdout, want)
+	}
+}
+
+func TestRegionEnvConflict(t *testing.T) {
+	tests := []struct {
+		flag, env string
+		want      bool
+	}{
+		{"eu-west-1", "eu-west-1", false},
+		{"eu-west-1", " EU-West-1", false},
+		{"eu-west-1", "us-east-1", true},
+		{"", "us-east-1", false},
+		{"eu-west-1", "", false},
+	}
+	for _, tt := range tests {
+		conflict, message := regionEnvConflict(tt.flag, "AWS_REGION", tt.env)
+		if conflict != tt.want {
+			t.Errorf("regionEnvConflict(%q, %q) = %v, want %v", tt.flag, tt.env, conflict, tt.want)
+		}
+		if want := "AWS_REGION is us-east-1 but -region is eu-west-1; -region wins"; conflict && message != want {
+			t.Errorf("message %q, want %q", message, want)
+		}
+	}
+
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n")
+	for env, warned := range map[string]bool{"AWS_DEFAULT_REGION=us-east-1": true, "AWS_DEFAULT_REGION=eu-west-1": false} {
+		result := runMain(t, home, "", []string{env}, "-profile", "dev", "-region", "eu-west-1", "-no-verify", "-no-last-save")
+		if got := strings.Contains(result.stderr, "-region wins"); got != warned {
+			t.Errorf("%s: warned %v, want %v: %q", env, got, warned, result.stderr)
+		}
+	}
+}
+
+func TestRequireRegion(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n")
+	// The fake AWS CLI has no configured region either.
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, callerIdentityScript)}
+	child := []string{"--", "sh", "-c", "echo child ran"}
+
+	result := runMain(t, home, "", env, append([]string{"-profile", "dev"}, child...)...)
+	if result.code != 0 || !strings.Contains(result.stdout, "child ran") {
+		t.Errorf("without -require-region: exit code %d, output %q", result.code, result.stdout)
+	}
+
+	for _, args := range [][]string{
+		{"-profile", "dev", "-require-region"},
+		{"-profile", "dev", "-require-region", "-watch", "60"},
+		append([]string{"-profile", "dev", "-require-region"}, child...),
+	} {
+		result := runMain(t, home, "", env, args...)
+		if result.code != exitError {
+			t.Errorf("%v: exit code %d, want %d", args, result.code, exitError)
+		}
+		if output := result.stdout + result.stderr; !strings.Contains(output, "no region for profile dev") {
+			t.Errorf("%v: output %q doesn't explain the failure", args, output)
+		}
+		if strings.Contains(result.stdout, "child ran") {
+			t.Errorf("%v: the command ran without a region", args)
+		}
+	}
+
+	result = runMain(t, home, "", env, "-profile", "dev", "-require-region", "-region", "eu-west-1")
+	if result.code != 0 {
+		t.Errorf("with -region: exit code %d, stderr %q", result.code, result.stderr)
+	}
+}
+
+func TestSearchRegions(t *testing.T) {
+	tests := []struct {
+		query string
+		first string
+	}{
+		{"ireland", "eu-west-1"},
+		{"  Ireland ", "eu-west-1"},
+		{"irelnd", "eu-west-1"},
+		{"frankfurt", "eu-central-1"},
+		{"virginia", "us-east-1"},
+		{"tokyo", "ap-northeast-1"},
+		{"eu-west-2", "eu-west-2"},
+	}
+	for _, tt := range tests {
+		matches := searchRegions(tt.query)
+		if len(matches) == 0 || matches[0] != tt.first {
+			t.Errorf("searchRegions(%q) = %v, want %s first", tt.query, matches, tt.first)
+		}
+	}
+
+	if matches := searchRegions("europe ireland"); len(matches) != 1 || matches[0] != "eu-west-1" {
+		t.Errorf("every term must match: %v", matches)
+	}
+	if matches := searchRegions("atlantis"); len(matches) != 0 {
+		t.Errorf("searchRegions(atlantis) = %v", matches)
+	}
+	if matches := searchRegions(""); len(matches) != len(regionNames) {
+		t.Errorf("an empty query matched %d regions, want all %d", len(matches), len(regionNames))
+	}
+}
+
+func TestPrintRegion(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n")
+	log := filepath.Join(t.TempDir(), "commands")
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, "echo \"$*\" >> "+log+"\n"+callerIdentityScript)}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-profile", "dev", "-print-region"}, "eu-west-1\n"},
+		{[]string{"-profile", "dev", "-print-region", "-region", "ap-south-1"}, "ap-south-1\n"},
+	}
+	for _, tt := range tests {
+		result := runMain(t, home, "", env, tt.args...)
+		if result.code != 0 || result.stdout != tt.want {
+			t.Errorf("%v: exit code %d, stdout %q, want only %q", tt.args, result.code, result.stdout, tt.want)
+		}
+	}
+	if commands := readFile(t, log); strings.Contains(commands, "sts") {
+		t.Errorf("-print-region called STS:\n%s", commands)
+	}
+	if got := readFile(t, filepath.Join(home, lastUsedFile)); got != "" {
+		t.Errorf("-print-region saved the last used profile %q", got)
+	}
+}
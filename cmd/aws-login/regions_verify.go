@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// maxRegionChecks bounds how many regions -verify-all-regions checks at
+// once.
+const maxRegionChecks = 4
+
+// regionCheck is the outcome of verifying a profile in one region.
+type regionCheck struct {
+	Region  string
+	Account string
+	Err     error
+}
+
+// verifyRegions runs `aws sts get-caller-identity` as profileName in each
+// region, at most maxRegionChecks at a time, and returns the results in the
+// order of regions.
+func verifyRegions(profileName string, regions []string) []regionCheck {
+	results := make([]regionCheck, len(regions))
+	slots := make(chan struct{}, maxRegionChecks)
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i] = regionCheck{Region: region}
+			output, err := awsCommand(profileName, "sts", "get-caller-identity", "--output", "json", "--region", region).CombinedOutput()
+			if err != nil {
+				reason, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+				if reason == "" {
+					reason = err.Error()
+				}
+				results[i].Err = errors.New(reason)
+				return
+			}
+			identity, err := parseCallerIdentity(output)
+			results[i].Account, results[i].Err = identity.Account, err
+		}(i, region)
+	}
+	wg.Wait()
+	return results
+}
+
+// summarizeRegionChecks formats one line per region and a total, and
+// reports whether every region succeeded.
+func summarizeRegionChecks(results []regionCheck) (string, bool) {
+	var b strings.Builder
+	passed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(&b, "%s FAILED: %v\n", result.Region, result.Err)
+			continue
+		}
+		passed++
+		fmt.Fprintf(&b, "%s ok %s\n", result.Region, result.Account)
+	}
+	fmt.Fprintf(&b, "%d of %d regions ok\n", passed, len(results))
+	return b.String(), passed == len(results)
+}
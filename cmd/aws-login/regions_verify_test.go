@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyRegions(t *testing.T) {
+	c := defaultConfig()
+	c.AWSCLIPath = fakeCLI(t, `while [ $# -gt 0 ]; do
+	if [ "$1" = --region ]; then region=$2; fi
+	shift
+done
+case "$region" in
+ap-east-1) echo 'An error occurred (InvalidClientTokenId): The security token included in the request is invalid' >&2; exit 254 ;;
+me-south-1) exit 255 ;;
+*) echo '{"Account": "123456789012", "Arn": "arn:aws:iam::123456789012:user/dev"}' ;;
+esac
+`)
+	setConfig(t, c)
+
+	results := verifyRegions("dev", []string{"us-east-1", "ap-east-1", "eu-west-1", "me-south-1"})
+	want := []regionCheck{
+		{Region: "us-east-1", Account: "123456789012"},
+		{Region: "ap-east-1", Err: errors.New("An error occurred (InvalidClientTokenId): The security token included in the request is invalid")},
+		{Region: "eu-west-1", Account: "123456789012"},
+		{Region: "me-south-1", Err: errors.New("exit status 255")},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("verifyRegions = %+v, want %+v", results, want)
+	}
+
+	summary, ok := summarizeRegionChecks(results)
+	wantSummary := "us-east-1 ok 123456789012\n" +
+		"ap-east-1 FAILED: An error occurred (InvalidClientTokenId): The security token included in the request is invalid\n" +
+		"eu-west-1 ok 123456789012\n" +
+		"me-south-1 FAILED: exit status 255\n" +
+		"2 of 4 regions ok\n"
+	if summary != wantSummary || ok {
+		t.Errorf("summary %v:\n%s\nwant false:\n%s", ok, summary, wantSummary)
+	}
+
+	if summary, ok := summarizeRegionChecks(results[:1]); !ok || summary != "us-east-1 ok 123456789012\n1 of 1 regions ok\n" {
+		t.Errorf("all ok: %v:\n%s", ok, summary)
+	}
+}
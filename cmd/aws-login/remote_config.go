@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteConfigEnv names the environment variable holding the URL of a
+// shared config file, which centrally managed setups use to give everyone
+// the same defaults.
+const remoteConfigEnv = "AWS_PROFILE_SELECTOR_REMOTE_CONFIG"
+
+const remoteConfigFile = ".aws-profile-selector-remote-config.toml"
+
+// remoteConfigTTL is how long a downloaded shared config is reused before it
+// is downloaded again.
+const remoteConfigTTL = time.Hour
+
+// remoteConfigTimeout bounds the download so an unreachable server doesn't
+// hold up every run.
+const remoteConfigTimeout = 5 * time.Second
+
+// remoteConfigKeys are the settings a shared config may set: policy such as
+// which profiles are offered and how they are ranked and verified. Anything
+// naming a program to run, like rank_command or aws_cli_path, stays under
+// the user's own control.
+var remoteConfigKeys = map[string]bool{
+	"verify":                 true,
+	"always_verify":          true,
+	"confirm":                true,
+	"confirm_account_switch": true,
+	"strict":                 true,
+	"safe_order":             true,
+	"allow":                  true,
+	"deny":                   true,
+	"match_all_terms":        true,
+	"aws_cli_min_version":    true,
+	"require_region":         true,
+	"verify_regions":         true,
+}
+
+// remoteConfigAllowed reports whether the shared config may set key, one of
+// remoteConfigKeys or a weights.* or regions.* setting.
+func remoteConfigAllowed(key string) bool {
+	return remoteConfigKeys[key] || strings.HasPrefix(key, "weights.") || strings.HasPrefix(key, "regions.")
+}
+
+// downloadedRemoteConfig is the shared config loadConfig downloaded, which
+// main caches with cacheRemoteConfig once the flags are parsed, so that
+// -read-only is respected.
+var downloadedRemoteConfig string
+
+// cacheRemoteConfig saves a freshly downloaded shared config for reuse
+// within remoteConfigTTL, unless in read-only mode.
+func cacheRemoteConfig() {
+	if downloadedRemoteConfig == "" || cfg.ReadOnly {
+		return
+	}
+	if err := os.WriteFile(remoteConfigFilePath(), []byte(downloadedRemoteConfig), 0644); err != nil {
+		warn("error caching the shared config: %v", err)
+	}
+}
+
+func remoteConfigFilePath() string {
+	return filepath.Join(homeDir(), remoteConfigFile)
+}
+
+// fetchRemoteConfig returns the shared config at rawURL: the cached copy if
+// it is younger than remoteConfigTTL, otherwise a fresh download, for which
+// fetched is set so the caller can cache it. When the download fails a
+// cached copy of any age is used instead, with a warning.
+func fetchRemoteConfig(client *http.Client, rawURL string) (content string, fetched bool, err error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" {
+		return "", false, fmt.Errorf("%s must be an https URL, got %q", remoteConfigEnv, rawURL)
+	}
+
+	path := remoteConfigFilePath()
+	cached, cacheErr := os.ReadFile(path)
+	if info, err := os.Stat(path); cacheErr == nil && err == nil && time.Since(info.ModTime()) < remoteConfigTTL {
+		return string(cached), false, nil
+	}
+
+	body, err := downloadRemoteConfig(client, rawURL)
+	if err != nil {
+		if cacheErr == nil {
+			warn("using the cached shared config: %v", err)
+			return string(cached), false, nil
+		}
+		return "", false, err
+	}
+	return body, true, nil
+}
+
+func downloadRemoteConfig(client *http.Client, rawURL string) (string, error) {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("error fetching shared config: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error fetching shared config: %s", resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error fetching shared config: %v", err)
+	}
+	return string(body), nil
+}
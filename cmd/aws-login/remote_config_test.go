@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchRemoteConfig(t *testing.T) {
+	testHome(t)
+	setConfig(t, defaultConfig())
+	requests := 0
+	status := http.StatusOK
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		fmt.Fprint(w, "safe_order = true\n")
+	}))
+	defer server.Close()
+	client := server.Client()
+	path := remoteConfigFilePath()
+
+	content, fetched, err := fetchRemoteConfig(client, server.URL)
+	if err != nil || !fetched || content != "safe_order = true\n" || requests != 1 {
+		t.Fatalf("first fetch: %q, %v, %v after %d requests", content, fetched, err, requests)
+	}
+
+	// Within the TTL the cached copy is used without a request.
+	writeFile(t, path, "strict = true\n")
+	content, fetched, err = fetchRemoteConfig(client, server.URL)
+	if err != nil || fetched || content != "strict = true\n" || requests != 1 {
+		t.Errorf("fresh cache: %q, %v, %v after %d requests", content, fetched, err, requests)
+	}
+
+	// Once it is older, it is downloaded again.
+	stale := time.Now().Add(-2 * remoteConfigTTL)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	content, fetched, err = fetchRemoteConfig(client, server.URL)
+	if err != nil || !fetched || content != "safe_order = true\n" || requests != 2 {
+		t.Errorf("stale cache: %q, %v, %v after %d requests", content, fetched, err, requests)
+	}
+
+	// Offline, the stale copy is better than nothing.
+	status = http.StatusServiceUnavailable
+	content, fetched, err = fetchRemoteConfig(client, server.URL)
+	if err != nil || fetched || content != "strict = true\n" {
+		t.Errorf("offline with a cache: %q, %v, %v", content, fetched, err)
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "using the cached shared config") {
+		t.Errorf("warnings %q", warnings)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fetchRemoteConfig(client, server.URL); err == nil {
+		t.Error("offline without a cache succeeded")
+	}
+
+	for _, rawURL := range []string{"http://config.example.com/aws.toml", "config.example.com/aws.toml", "://"} {
+		if _, _, err := fetchRemoteConfig(client, rawURL); err == nil {
+			t.Errorf("fetching %q succeeded", rawURL)
+		}
+	}
+}
+
+func TestRemoteConfigAllowed(t *testing.T) {
+	for key, want := range map[string]bool{
+		"deny":               true,
+		"safe_order":         true,
+		"weights.account_id": true,
+		"regions.prod":       true,
+		"rank_command":       false,
+		"aws_cli_path":       false,
+		"profiles.dev.env":   false,
+	} {
+		if got := remoteConfigAllowed(key); got != want {
+			t.Errorf("remoteConfigAllowed(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	c := defaultConfig()
+	if err := applyConfigFile(&c, "safe_order = true\nrank_command = \"curl evil\"\n", remoteConfigAllowed); err == nil || !strings.Contains(err.Error(), "rank_command can't be set by a shared config") {
+		t.Errorf("error %v", err)
+	}
+	if c.RankCommand != "" {
+		t.Errorf("the shared config set rank_command to %q", c.RankCommand)
+	}
+}
+
+func TestCacheRemoteConfigReadOnly(t *testing.T) {
+	testHome(t)
+	saved := downloadedRemoteConfig
+	t.Cleanup(func() { downloadedRemoteConfig = saved })
+	downloadedRemoteConfig = "strict = true\n"
+
+	for _, readOnly := range []bool{true, false} {
+		c := defaultConfig()
+		c.ReadOnly = readOnly
+		setConfig(t, c)
+		cacheRemoteConfig()
+		if cached := readFile(t, remoteConfigFilePath()) != ""; cached == readOnly {
+			t.Errorf("read-only %v: cached %v", readOnly, cached)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// outputSchema is the JSON Schema of what -json prints: a jsonResult for a
+// selected profile, a whichResult for -which, or a jsonError on failure.
+func outputSchema() map[string]any {
+	return map[string]any{
+		"$schema": jsonSchemaDialect,
+		"title":   "aws-login -json output",
+		"oneOf": []any{
+			objectSchema(jsonResult{}),
+			objectSchema(whichResult{}),
+			objectSchema(jsonError{}),
+		},
+	}
+}
+
+// objectSchema describes the JSON encoding of the struct v from its json
+// struct tags. Fields without omitempty are required.
+func objectSchema(v any) map[string]any {
+	t := reflect.TypeOf(v)
+	properties := make(map[string]any)
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = map[string]any{"type": jsonSchemaType(field.Type)}
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"title":                t.Name(),
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func jsonSchemaType(t reflect.Type) string {
+	if t == reflect.TypeOf(json.RawMessage(nil)) {
+		// Embedded AWS CLI output, such as the caller identity.
+		return "object"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	}
+	return "string"
+}
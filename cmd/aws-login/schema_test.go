@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// schemaObject is the part of an objectSchema the tests check output against.
+type schemaObject struct {
+	Title      string                       `json:"title"`
+	Properties map[string]map[string]string `json:"properties"`
+	Required   []string                     `json:"required"`
+}
+
+// matches reports whether the JSON object has only the schema's
+// properties, with their types, and all of its required ones.
+func (s schemaObject) matches(object map[string]any) bool {
+	for name, value := range object {
+		property, ok := s.Properties[name]
+		if !ok {
+			return false
+		}
+		var valid bool
+		switch value.(type) {
+		case string:
+			valid = property["type"] == "string"
+		case float64:
+			valid = property["type"] == "integer" || property["type"] == "number"
+		case map[string]any:
+			valid = property["type"] == "object"
+		}
+		if !valid {
+			return false
+		}
+	}
+	for _, name := range s.Required {
+		if _, ok := object[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestJSONSchema(t *testing.T) {
+	result := runMain(t, t.TempDir(), "", nil, "-json-schema")
+	if result.code != 0 {
+		t.Fatalf("exit code %d: %s", result.code, result.stdout)
+	}
+	var schema struct {
+		OneOf []schemaObject `json:"oneOf"`
+	}
+	if err := json.Unmarshal([]byte(result.stdout), &schema); err != nil {
+		t.Fatalf("schema %q isn't JSON: %v", result.stdout, err)
+	}
+	var titles []string
+	for _, object := range schema.OneOf {
+		titles = append(titles, object.Title)
+	}
+	if !slices.Equal(titles, []string{"jsonResult", "whichResult", "jsonError"}) {
+		t.Fatalf("schema describes %v, want jsonResult, whichResult and jsonError", titles)
+	}
+
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n")
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, callerIdentityScript)}
+	tests := []struct {
+		args  []string
+		title string
+	}{
+		{[]string{"-profile", "dev", "-json", "-no-last-save"}, "jsonResult"},
+		{[]string{"-profile", "dev", "-json", "-no-verify", "-no-last-save"}, "jsonResult"},
+		{[]string{"-which", "-json"}, "whichResult"},
+		{[]string{"-profile", "missing", "-json"}, "jsonError"},
+	}
+	for _, tt := range tests {
+		result := runMain(t, home, "", env, tt.args...)
+		var object map[string]any
+		if err := json.Unmarshal([]byte(result.stdout), &object); err != nil {
+			t.Errorf("%v: stdout %q isn't JSON: %v", tt.args, result.stdout, err)
+			continue
+		}
+		var matched []string
+		for _, s := range schema.OneOf {
+			if s.matches(object) {
+				matched = append(matched, s.Title)
+			}
+		}
+		if !slices.Equal(matched, []string{tt.title}) {
+			t.Errorf("%v: output %s matches %v, want only %s", tt.args, result.stdout, matched, tt.title)
+		}
+	}
+}
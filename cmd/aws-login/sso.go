@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ssoCacheToken is an access token cached by `aws sso login` under
+// ~/.aws/sso/cache. The same directory also holds client registrations,
+// which have no start URL or access token.
+type ssoCacheToken struct {
+	StartURL    string `json:"startUrl"`
+	Region      string `json:"region"`
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+// ssoAccount and ssoRole are entries of the `aws sso list-accounts` and
+// `aws sso list-account-roles` responses.
+type ssoAccount struct {
+	AccountID   string `json:"accountId"`
+	AccountName string `json:"accountName"`
+}
+
+type ssoRole struct {
+	RoleName string `json:"roleName"`
+}
+
+func ssoCacheDir() string {
+	return filepath.Join(homeDir(), ".aws", "sso", "cache")
+}
+
+// readSSOCacheTokens returns the unexpired access tokens in dir, one per
+// start URL.
+func readSSOCacheTokens(dir string, now time.Time) ([]ssoCacheToken, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []ssoCacheToken
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var token ssoCacheToken
+		if json.Unmarshal(content, &token) != nil || token.StartURL == "" || token.AccessToken == "" {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, token.ExpiresAt)
+		if err != nil || !expiresAt.After(now) || seen[token.StartURL] {
+			continue
+		}
+		seen[token.StartURL] = true
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// ssoTokenExpiry returns when the cached access token for startURL in dir
+// expires, or false if there is no unexpired one.
+func ssoTokenExpiry(dir, startURL string, now time.Time) (time.Time, bool) {
+	tokens, err := readSSOCacheTokens(dir, now)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, token := range tokens {
+		if token.StartURL == startURL {
+			expiresAt, _ := time.Parse(time.RFC3339, token.ExpiresAt)
+			return expiresAt, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ssoNameUnsafeRegexp matches the characters of account and role names that
+// aren't allowed in profile names.
+var ssoNameUnsafeRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// ssoProfile synthesizes the profile for a role in an account reachable
+// through token.
+func ssoProfile(token ssoCacheToken, account ssoAccount, role ssoRole) AWSProfile {
+	return AWSProfile{
+		Name:         "sso-" + account.AccountID + "-" + ssoNameUnsafeRegexp.ReplaceAllString(role.RoleName, "-"),
+		AWSAccountID: account.AccountID,
+		Region:       token.Region,
+		SSOStartURL:  token.StartURL,
+		SSOAccountID: account.AccountID,
+		SSORoleName:  role.RoleName,
+		Description:  account.AccountName,
+	}
+}
+
+// ssoProfileSection returns the ~/.aws/config section that lets the AWS CLI
+// use a profile synthesized by ssoProfile.
+func ssoProfileSection(profile AWSProfile) []string {
+	return []string{
+		"[profile " + profile.Name + "]",
+		"sso_start_url = " + profile.SSOStartURL,
+		"sso_region = " + profile.Region,
+		"sso_account_id = " + profile.SSOAccountID,
+		"sso_role_name = " + profile.SSORoleName,
+		"region = " + profile.Region,
+	}
+}
+
+// saveSSOProfile adds the section for profile, synthesized by ssoProfile, to
+// the AWS CLI config file at path so that it can be verified and used with
+// AWS_PROFILE like any other profile. It reports whether the file was
+// changed, which it isn't if the section is already there.
+func saveSSOProfile(path string, profile AWSProfile) (bool, error) {
+	section := ssoProfileSection(profile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return false, err
+		}
+		return true, os.WriteFile(path, []byte(strings.Join(section, "\n")+"\n"), 0600)
+	}
+
+	added := false
+	err := rewriteINIFile(path, func(lines []string) ([]string, error) {
+		for _, line := range lines {
+			if name, ok := iniSectionName(line); ok && name == "profile "+profile.Name {
+				return lines, nil
+			}
+		}
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		added = true
+		return append(lines, section...), nil
+	})
+	return added, err
+}
+
+// loadProfilesFromSSOCache lists the accounts and roles available with each
+// cached SSO token and returns a profile for every role.
+func loadProfilesFromSSOCache() (map[string]AWSProfile, error) {
+	tokens, err := readSSOCacheTokens(ssoCacheDir(), time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]AWSProfile)
+	for _, token := range tokens {
+		var accounts struct {
+			AccountList []ssoAccount `json:"accountList"`
+		}
+		if err := runSSOCommand(token, &accounts, "list-accounts"); err != nil {
+			return nil, err
+		}
+		for _, account := range accounts.AccountList {
+			var roles struct {
+				RoleList []ssoRole `json:"roleList"`
+			}
+			if err := runSSOCommand(token, &roles, "list-account-roles", "--account-id", account.AccountID); err != nil {
+				return nil, err
+			}
+			for _, role := range roles.RoleList {
+				profile := ssoProfile(token, account, role)
+				profiles[profile.Name] = profile
+			}
+		}
+	}
+	return filterAllowedProfiles(profiles, cfg.Allow, cfg.Deny), nil
+}
+
+// runSSOCommand runs an `aws sso` subcommand with token and decodes its JSON
+// output into v.
+func runSSOCommand(token ssoCacheToken, v any, args ...string) error {
+	args = append([]string{"sso"}, args...)
+	args = append(args, "--access-token", token.AccessToken, "--region", token.Region, "--output", "json")
+	output, err := exec.Command(cfg.AWSCLIPath, args...).Output()
+	if err != nil {
+		return fmt.Errorf("error running aws sso %s for %s: %v", args[1], token.StartURL, err)
+	}
+	if err := json.Unmarshal(output, v); err != nil {
+		return fmt.Errorf("error parsing aws sso %s response: %v", args[1], err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadSSOCacheTokens(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(dir, "a1.json"), `{"startUrl": "https://acme.awsapps.com/start", "region": "us-east-1", "accessToken": "token-a", "expiresAt": "2024-05-01T20:00:00Z"}`)
+	// A second token for the same start URL is ignored.
+	writeFile(t, filepath.Join(dir, "a2.json"), `{"startUrl": "https://acme.awsapps.com/start", "region": "us-east-1", "accessToken": "token-a2", "expiresAt": "2024-05-01T21:00:00Z"}`)
+	writeFile(t, filepath.Join(dir, "b.json"), `{"startUrl": "https://other.awsapps.com/start", "region": "eu-west-1", "accessToken": "token-b", "expiresAt": "2024-05-01T11:00:00Z"}`)
+	writeFile(t, filepath.Join(dir, "client.json"), `{"clientId": "abc", "clientSecret": "secret", "expiresAt": "2024-08-01T00:00:00Z"}`)
+	writeFile(t, filepath.Join(dir, "broken.json"), `{"startUrl": `)
+	writeFile(t, filepath.Join(dir, "notes.txt"), `{"startUrl": "https://notes.awsapps.com/start", "accessToken": "t", "expiresAt": "2024-05-01T20:00:00Z"}`)
+
+	tokens, err := readSSOCacheTokens(dir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ssoCacheToken{{
+		StartURL:    "https://acme.awsapps.com/start",
+		Region:      "us-east-1",
+		AccessToken: "token-a",
+		ExpiresAt:   "2024-05-01T20:00:00Z",
+	}}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens %+v, want %+v", tokens, want)
+	}
+
+	if expiresAt, ok := ssoTokenExpiry(dir, "https://acme.awsapps.com/start", now); !ok || !expiresAt.Equal(now.Add(8*time.Hour)) {
+		t.Errorf("expiry %v, %v", expiresAt, ok)
+	}
+	if _, ok := ssoTokenExpiry(dir, "https://other.awsapps.com/start", now); ok {
+		t.Error("found an expiry for an expired token")
+	}
+}
+
+func TestLoadProfilesFromSSOCache(t *testing.T) {
+	home := testHome(t)
+	writeFile(t, filepath.Join(home, ".aws", "sso", "cache", "token.json"), `{"startUrl": "https://acme.awsapps.com/start", "region": "us-west-2", "accessToken": "token-a", "expiresAt": "2999-01-01T00:00:00Z"}`)
+	setConfig(t, config{AWSCLIPath: fakeCLI(t, `case "$2" in
+list-accounts) echo '{"accountList": [{"accountId": "111111111111", "accountName": "Billing"}]}' ;;
+list-account-roles) echo '{"roleList": [{"roleName": "ReadOnly"}, {"roleName": "Admin Access"}]}' ;;
+*) exit 1 ;;
+esac
+`)})
+
+	profiles, err := loadProfilesFromSSOCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]AWSProfile{
+		"sso-111111111111-ReadOnly": {
+			Name:         "sso-111111111111-ReadOnly",
+			AWSAccountID: "111111111111",
+			Region:       "us-west-2",
+			SSOStartURL:  "https://acme.awsapps.com/start",
+			SSOAccountID: "111111111111",
+			SSORoleName:  "ReadOnly",
+			Description:  "Billing",
+		},
+		"sso-111111111111-Admin-Access": {
+			Name:         "sso-111111111111-Admin-Access",
+			AWSAccountID: "111111111111",
+			Region:       "us-west-2",
+			SSOStartURL:  "https://acme.awsapps.com/start",
+			SSOAccountID: "111111111111",
+			SSORoleName:  "Admin Access",
+			Description:  "Billing",
+		},
+	}
+	if !reflect.DeepEqual(profiles, want) {
+		t.Errorf("profiles %+v, want %+v", profiles, want)
+	}
+}
+
+func TestSaveSSOProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".aws", "config")
+	profile := ssoProfile(
+		ssoCacheToken{StartURL: "https://acme.awsapps.com/start", Region: "us-west-2"},
+		ssoAccount{AccountID: "111111111111"},
+		ssoRole{RoleName: "ReadOnly"},
+	)
+	section := "[profile sso-111111111111-ReadOnly]\nsso_start_url = https://acme.awsapps.com/start\nsso_region = us-west-2\nsso_account_id = 111111111111\nsso_role_name = ReadOnly\nregion = us-west-2\n"
+
+	if added, err := saveSSOProfile(path, profile); err != nil || !added {
+		t.Fatalf("first save: %v, %v", added, err)
+	}
+	if got := readFile(t, path); got != section {
+		t.Errorf("new file:\n%s\nwant:\n%s", got, section)
+	}
+	if added, err := saveSSOProfile(path, profile); err != nil || added {
+		t.Errorf("second save: %v, %v", added, err)
+	}
+
+	writeFile(t, path, "[default]\nregion = us-east-1\n")
+	if added, err := saveSSOProfile(path, profile); err != nil || !added {
+		t.Fatalf("save to an existing file: %v, %v", added, err)
+	}
+	if got, want := readFile(t, path), "[default]\nregion = us-east-1\n\n"+section; got != want {
+		t.Errorf("existing file:\n%s\nwant:\n%s", got, want)
+	}
+}
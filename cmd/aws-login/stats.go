@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const usageFile = ".aws-profile-selector-usage"
+
+// getUsageCounts returns how many times each profile has been selected,
+// keyed by profile name.
+func getUsageCounts() map[string]int {
+	counts := make(map[string]int)
+	content, err := os.ReadFile(filepath.Join(homeDir(), usageFile))
+	if err != nil {
+		return counts
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if count, err := strconv.Atoi(fields[1]); err == nil {
+			counts[fields[0]] = count
+		}
+	}
+	return counts
+}
+
+func saveUsageCounts(counts map[string]int) error {
+	var content strings.Builder
+	for _, c := range rankCounts(counts) {
+		fmt.Fprintf(&content, "%s %d\n", c.Value, c.Count)
+	}
+	return os.WriteFile(filepath.Join(homeDir(), usageFile), []byte(content.String()), 0644)
+}
+
+// countProfileUse adds one to the number of times profileName has been
+// selected.
+func countProfileUse(profileName string) error {
+	return withStateLock(func() error {
+		counts := getUsageCounts()
+		counts[profileName]++
+		return saveUsageCounts(counts)
+	})
+}
+
+// usageStats renders the -stats table of how often each profile has been
+// selected, most used first.
+func usageStats(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "No profiles selected yet.\n"
+	}
+	var buf strings.Builder
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tSELECTED")
+	for _, c := range rankCounts(counts) {
+		fmt.Fprintf(w, "%s\t%d\n", c.Value, c.Count)
+	}
+	w.Flush()
+	return buf.String()
+}
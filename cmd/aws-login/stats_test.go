@@ -0,0 +1,50 @@
+package main
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountProfileUse(t *testing.T) {
+	home := testHome(t)
+	for _, name := range []string{"dev", "prod", "dev", "sandbox", "dev", "prod"} {
+		if err := countProfileUse(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := getUsageCounts(), map[string]int{"dev": 3, "prod": 2, "sandbox": 1}; !maps.Equal(got, want) {
+		t.Errorf("counts %v, want %v", got, want)
+	}
+	if got, want := readFile(t, filepath.Join(home, usageFile)), "dev 3\nprod 2\nsandbox 1\n"; got != want {
+		t.Errorf("usage file %q, want %q", got, want)
+	}
+
+	want := "PROFILE  SELECTED\ndev      3\nprod     2\nsandbox  1\n"
+	if got := usageStats(getUsageCounts()); got != want {
+		t.Errorf("usageStats:\n%s\nwant:\n%s", got, want)
+	}
+	if got := usageStats(nil); got != "No profiles selected yet.\n" {
+		t.Errorf("usageStats(nil) = %q", got)
+	}
+}
+
+func TestStatsCountsSelections(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n\n[prod]\naws_access_key_id = AKIA2\naws_secret_access_key = s2\n")
+	for _, args := range [][]string{
+		{"-profile", "prod"},
+		{"-profile", "dev"},
+		{"-profile", "prod"},
+		// Not counted.
+		{"-profile", "dev", "-no-last-save"},
+	} {
+		if result := runMain(t, home, "", nil, append(args, "-no-verify")...); result.code != 0 {
+			t.Fatalf("%v: exit code %d, output %q", args, result.code, result.stdout+result.stderr)
+		}
+	}
+	result := runMain(t, home, "", nil, "-stats")
+	if want := "PROFILE  SELECTED\nprod     2\ndev      1\n"; result.stdout != want {
+		t.Errorf("-stats printed:\n%s\nwant:\n%s", result.stdout, want)
+	}
+}
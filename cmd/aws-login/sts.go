@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits AWS places on the duration of an assumed role session.
+const (
+	minSessionDuration = 900
+	maxSessionDuration = 43200
+	// AWS caps a session assumed with another role's credentials, a role
+	// chain, at an hour whatever the role allows.
+	maxChainedSessionDuration = 3600
+)
+
+// assumeRoleResponse is the response of `aws sts assume-role`.
+type assumeRoleResponse struct {
+	Credentials struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		SessionToken    string    `json:"SessionToken"`
+		Expiration      time.Time `json:"Expiration"`
+	} `json:"Credentials"`
+	AssumedRoleUser struct {
+		AssumedRoleID string `json:"AssumedRoleId"`
+		Arn           string `json:"Arn"`
+	} `json:"AssumedRoleUser"`
+}
+
+// sessionTag is a tag attached to an assumed role session.
+type sessionTag struct {
+	Key   string
+	Value string
+}
+
+// Limits AWS places on session tags.
+const (
+	maxSessionTagKeyLength   = 128
+	maxSessionTagValueLength = 256
+)
+
+var sessionTagRegexp = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// parseSessionTag parses a "Key=Value" session tag, checking the key and
+// value against the characters and lengths AWS accepts.
+func parseSessionTag(tag string) (sessionTag, error) {
+	key, value, ok := strings.Cut(tag, "=")
+	if !ok {
+		return sessionTag{}, fmt.Errorf("session tag %q is not Key=Value", tag)
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if key == "" || utf8.RuneCountInString(key) > maxSessionTagKeyLength || !sessionTagRegexp.MatchString(key) {
+		return sessionTag{}, fmt.Errorf("invalid session tag key %q", key)
+	}
+	if utf8.RuneCountInString(value) > maxSessionTagValueLength || !sessionTagRegexp.MatchString(value) {
+		return sessionTag{}, fmt.Errorf("invalid session tag value %q", value)
+	}
+	return sessionTag{Key: key, Value: value}, nil
+}
+
+// sessionTagArgs returns the --tags arguments of `aws sts assume-role` for
+// tags, or nothing if there are none.
+func sessionTagArgs(tags []sessionTag) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	args := []string{"--tags"}
+	for _, tag := range tags {
+		args = append(args, "Key="+tag.Key+",Value="+tag.Value)
+	}
+	return args
+}
+
+func validateSessionDuration(seconds int) error {
+	if seconds < minSessionDuration || seconds > maxSessionDuration {
+		return fmt.Errorf("duration must be between %d and %d seconds, got %d", minSessionDuration, maxSessionDuration, seconds)
+	}
+	return nil
+}
+
+// validateChainedDuration checks seconds against the cap on role chains
+// when the profile's source profile itself assumes a role.
+func validateChainedDuration(profile AWSProfile, profiles map[string]AWSProfile, seconds int) error {
+	source, ok := profiles[profile.SourceProfile]
+	if profile.RoleARN == "" || !ok || source.RoleARN == "" || seconds <= maxChainedSessionDuration {
+		return nil
+	}
+	return fmt.Errorf("%s assumes its role with the role of %s, so its sessions can last at most %d seconds, got %d", profile.Name, source.Name, maxChainedSessionDuration, seconds)
+}
+
+// assumeRoleCommand builds the command that assumes the profile's role using
+// its source profile's credentials, with the session tags configured for the
+// profile.
+func assumeRoleCommand(profile AWSProfile, durationSeconds int) *exec.Cmd {
+	args := []string{
+		"sts", "assume-role",
+		"--role-arn", profile.RoleARN,
+		"--role-session-name", "aws-login-" + profile.Name,
+		"--duration-seconds", strconv.Itoa(durationSeconds),
+		"--output", "json",
+	}
+	args = append(args, sessionTagArgs(cfg.Profiles[profile.Name].Tags)...)
+	return awsCommand(profile.SourceProfile, args...)
+}
+
+// assumeRoleIdentity assumes the profile's role and returns the assumed
+// identity in the shape of `aws sts get-caller-identity` output, so the
+// temporary credentials in the response are never printed, along with the
+// time the session expires.
+func assumeRoleIdentity(profile AWSProfile, durationSeconds int) ([]byte, time.Time, error) {
+	output, err := assumeRoleCommand(profile, durationSeconds).CombinedOutput()
+	if err != nil {
+		return output, time.Time{}, err
+	}
+
+	var response assumeRoleResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, time.Time{}, fmt.Errorf("error parsing assume-role response: %v", err)
+	}
+	identity, err := json.MarshalIndent(callerIdentity{
+		UserID:  response.AssumedRoleUser.AssumedRoleID,
+		Account: accountFromARN(response.AssumedRoleUser.Arn),
+		Arn:     response.AssumedRoleUser.Arn,
+	}, "", "    ")
+	return identity, response.Credentials.Expiration, err
+}
+
+// expiryWarningThreshold is how close to expiring a session must be for
+// -expires to warn about it.
+const expiryWarningThreshold = 15 * time.Minute
+
+// timeUntilExpiry returns how long a session expiring at expiration has left
+// at now, or zero if it has already expired.
+func timeUntilExpiry(expiration, now time.Time) time.Duration {
+	if remaining := expiration.Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// accountFromARN returns the account id field of an ARN.
+func accountFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// containsArgs reports whether want appears in args as consecutive
+// arguments.
+func containsArgs(args []string, want ...string) bool {
+	for i := 0; i+len(want) <= len(args); i++ {
+		if slices.Equal(args[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCommandsRequestJSONOutput(t *testing.T) {
+	setConfig(t, defaultConfig())
+	profile := AWSProfile{Name: "admin", RoleARN: "arn:aws:iam::111111111111:role/admin", SourceProfile: "base"}
+	for name, args := range map[string][]string{
+		"get-caller-identity": callerIdentityCommand("dev").Args,
+		"assume-role":         assumeRoleCommand(profile, 3600).Args,
+	} {
+		if !containsArgs(args, "--output", "json") {
+			t.Errorf("%s command %v doesn't include --output json", name, args)
+		}
+	}
+}
+
+func TestSessionDuration(t *testing.T) {
+	setConfig(t, defaultConfig())
+	for _, seconds := range []int{minSessionDuration, 3600, maxSessionDuration} {
+		if err := validateSessionDuration(seconds); err != nil {
+			t.Errorf("validateSessionDuration(%d): %v", seconds, err)
+		}
+	}
+	for _, seconds := range []int{-1, 0, minSessionDuration - 1, maxSessionDuration + 1} {
+		if err := validateSessionDuration(seconds); err == nil {
+			t.Errorf("validateSessionDuration(%d) accepted an out-of-range duration", seconds)
+		}
+	}
+
+	profile := AWSProfile{Name: "admin", RoleARN: "arn:aws:iam::111111111111:role/admin", SourceProfile: "base"}
+	if args := assumeRoleCommand(profile, 7200).Args; !containsArgs(args, "--duration-seconds", "7200") {
+		t.Errorf("assume-role command %q doesn't pass the duration", args)
+	}
+
+	profiles := map[string]AWSProfile{
+		"admin": profile,
+		"base":  {Name: "base", AWSAccessKeyID: "AKIA1"},
+		"chain": {Name: "chain", RoleARN: "arn:aws:iam::222222222222:role/chain", SourceProfile: "admin"},
+	}
+	for _, tt := range []struct {
+		profile string
+		seconds int
+		ok      bool
+	}{
+		{"admin", maxSessionDuration, true},
+		{"chain", maxChainedSessionDuration, true},
+		{"chain", maxChainedSessionDuration + 1, false},
+	} {
+		if err := validateChainedDuration(profiles[tt.profile], profiles, tt.seconds); (err == nil) != tt.ok {
+			t.Errorf("validateChainedDuration(%s, %d) = %v", tt.profile, tt.seconds, err)
+		}
+	}
+}
+
+func TestDurationFlagRejectsOutOfRange(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[admin]\nrole_arn = arn:aws:iam::111111111111:role/admin\nsource_profile = base\n[base]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n")
+
+	result := runMain(t, home, "", nil, "-profile", "admin", "-duration", "60")
+	if result.code != exitError || !strings.Contains(result.stdout, "duration must be between") {
+		t.Errorf("exit code %d, output %q, want the duration rejected", result.code, result.stdout)
+	}
+}
+
+func TestDurationFlagNotSaved(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[admin]\nrole_arn = arn:aws:iam::111111111111:role/admin\nsource_profile = base\n[base]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\n[chain]\nrole_arn = arn:aws:iam::222222222222:role/chain\nsource_profile = admin\n")
+	log := filepath.Join(t.TempDir(), "calls")
+	aws := fakeCLI(t, `echo "$*" >> `+log+`
+case "$1 $2" in
+"sts assume-role") echo '{"Credentials": {"Expiration": "2999-01-01T00:00:00Z"}, "AssumedRoleUser": {"Arn": "arn:aws:sts::111111111111:assumed-role/admin/aws-login"}}' ;;
+*) exit 1 ;;
+esac
+`)
+	env := []string{"AWS_CLI_PATH=" + aws}
+
+	result := runMain(t, home, "", env, "-profile", "admin", "-duration", "7200", "-no-last-save")
+	if result.code != 0 {
+		t.Fatalf("exit code %d: %s%s", result.code, result.stdout, result.stderr)
+	}
+	calls := readFile(t, log)
+	if !strings.Contains(calls, "--duration-seconds 7200") || strings.Contains(calls, "configure set") {
+		t.Errorf("AWS CLI calls %q, want only the assume-role call with the duration", calls)
+	}
+
+	result = runMain(t, home, "", env, "-profile", "chain", "-duration", "7200", "-no-last-save")
+	if result.code != exitError || !strings.Contains(result.stdout, "at most 3600 seconds") {
+		t.Errorf("chained role: exit code %d, output %q, want the duration rejected", result.code, result.stdout)
+	}
+}
+
+func TestSessionTags(t *testing.T) {
+	c := defaultConfig()
+	if err := applyConfigFile(&c, "[profiles.admin]\ntags = [\"Team=platform\", \"CostCenter = 1234\"]\n", nil); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, c)
+
+	profile := AWSProfile{Name: "admin", RoleARN: "arn:aws:iam::111111111111:role/admin", SourceProfile: "base"}
+	args := assumeRoleCommand(profile, 3600).Args
+	if !containsArgs(args, "--tags", "Key=Team,Value=platform", "Key=CostCenter,Value=1234") {
+		t.Errorf("assume-role command %q doesn't pass the tags", args)
+	}
+	if got := sessionTagArgs(nil); got != nil {
+		t.Errorf("sessionTagArgs(nil) = %q, want no arguments", got)
+	}
+
+	for _, tag := range []string{"Team", "=platform", "Team=plat,form", "Team=a;b", strings.Repeat("k", maxSessionTagKeyLength+1) + "=v"} {
+		if _, err := parseSessionTag(tag); err == nil {
+			t.Errorf("parseSessionTag(%q) accepted a malformed tag", tag)
+		}
+		c := defaultConfig()
+		if err := applyConfigFile(&c, "[profiles.admin]\ntags = [\""+tag+"\"]\n", nil); err == nil {
+			t.Errorf("config with tag %q was accepted", tag)
+		}
+	}
+}
+
+func TestUnsentSessionTagsWarning(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[base]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = us-east-1\n[admin]\nrole_arn = arn:aws:iam::111111111111:role/admin\nsource_profile = base\nregion = us-east-1\n")
+	writeFile(t, filepath.Join(home, ".config", "aws-profile-selector", "config.toml"), "[profiles.admin]\ntags = [\"Team=platform\"]\n\n[profiles.base]\ntags = [\"Team=platform\"]\n")
+
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{"admin", "Warning: session tags for admin are only sent with -duration; they aren't sent this time\n"},
+		{"base", "Warning: profile base has session tags but doesn't assume a role; they aren't sent\n"},
+	}
+	for _, tt := range tests {
+		result := runMain(t, home, "", nil, "-profile", tt.profile, "-no-verify", "-no-last-save")
+		if result.code != 0 || result.stderr != tt.want {
+			t.Errorf("%s: exit code %d, stderr %q, want %q", tt.profile, result.code, result.stderr, tt.want)
+		}
+	}
+}
+
+func TestTimeUntilExpiry(t *testing.T) {
+	c := defaultConfig()
+	c.AWSCLIPath = fakeCLI(t, `echo '{"Credentials": {"AccessKeyId": "ASIA1", "SecretAccessKey": "s1", "SessionToken": "t1", "Expiration": "2024-05-01T13:00:00+00:00"}, "AssumedRoleUser": {"AssumedRoleId": "AROA1:aws-login-admin", "Arn": "arn:aws:sts::111111111111:assumed-role/admin/aws-login-admin"}}'`)
+	setConfig(t, c)
+
+	_, expiration, err := assumeRoleIdentity(AWSProfile{Name: "admin", RoleARN: "arn:aws:iam::111111111111:role/admin", SourceProfile: "dev"}, 3600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC); !expiration.Equal(want) {
+		t.Fatalf("expiration %v, want %v", expiration, want)
+	}
+
+	tests := []struct {
+		now  time.Time
+		want time.Duration
+	}{
+		{time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), time.Hour},
+		{time.Date(2024, 5, 1, 12, 50, 30, 0, time.UTC), 9*time.Minute + 30*time.Second},
+		{time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC), 0},
+		{time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC), 0},
+	}
+	for _, tt := range tests {
+		if got := timeUntilExpiry(expiration, tt.now); got != tt.want {
+			t.Errorf("at %v: %v left, want %v", tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestReportExpiry(t *testing.T) {
+	home := testHome(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeFile(t, filepath.Join(home, ".aws", "sso", "cache", "token.json"), `{"startUrl": "https://acme.awsapps.com/start", "region": "us-east-1", "accessToken": "t", "expiresAt": "2024-05-01T12:05:00Z"}`)
+
+	tests := []struct {
+		name       string
+		profile    AWSProfile
+		expiration time.Time
+		warned     bool
+	}{
+		{"assumed", AWSProfile{Name: "admin"}, now.Add(time.Hour), false},
+		{"assumed, nearly expired", AWSProfile{Name: "admin"}, now.Add(10 * time.Minute), true},
+		{"sso cache", AWSProfile{Name: "sso", SSOStartURL: "https://acme.awsapps.com/start"}, time.Time{}, true},
+		{"unknown", AWSProfile{Name: "dev"}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		setConfig(t, defaultConfig())
+		reportExpiry(tt.profile, tt.expiration, now)
+		if warned := len(warnings) > 0; warned != tt.warned {
+			t.Errorf("%s: warned %v, want %v: %v", tt.name, warned, tt.warned, warnings)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// profileCount is the number of profiles sharing a value, such as an
+// account id.
+type profileCount struct {
+	Value string
+	Count int
+}
+
+// countProfiles counts profiles by the value key returns for each, most
+// common first and then by value.
+func countProfiles(profiles map[string]AWSProfile, key func(AWSProfile) string) []profileCount {
+	counts := make(map[string]int)
+	for _, profile := range profiles {
+		counts[key(profile)]++
+	}
+	return rankCounts(counts)
+}
+
+// rankCounts returns counts most common first and then by value.
+func rankCounts(counts map[string]int) []profileCount {
+	var result []profileCount
+	for value, count := range counts {
+		result = append(result, profileCount{Value: value, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// profileSummary describes how many profiles there are per account and per
+// environment class.
+func profileSummary(profiles map[string]AWSProfile) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%d profiles\n", len(profiles))
+
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\naccount\tprofiles")
+	for _, c := range countProfiles(profiles, func(p AWSProfile) string { return p.AWSAccountID }) {
+		fmt.Fprintf(w, "%s\t%d\n", displayValue(accountLabel(c.Value, accountNames)), c.Count)
+	}
+	fmt.Fprintln(w, "\nenvironment\tprofiles")
+	for _, c := range countProfiles(profiles, func(p AWSProfile) string { return profileEnvironment(p.Name) }) {
+		fmt.Fprintf(w, "%s\t%d\n", c.Value, c.Count)
+	}
+	w.Flush()
+	return buf.String()
+}
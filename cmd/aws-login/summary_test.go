@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProfileSummary(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"api-prod":     {Name: "api-prod", AWSAccountID: "222222222222"},
+		"billing-prod": {Name: "billing-prod", AWSAccountID: "222222222222"},
+		"api-test":     {Name: "api-test", AWSAccountID: "111111111111"},
+		"api-dev":      {Name: "api-dev", AWSAccountID: "111111111111"},
+		"web-dev":      {Name: "web-dev", AWSAccountID: "111111111111"},
+		"sandbox":      {Name: "sandbox"},
+	}
+
+	byAccount := countProfiles(profiles, func(p AWSProfile) string { return p.AWSAccountID })
+	if want := []profileCount{{"111111111111", 3}, {"222222222222", 2}, {"", 1}}; !reflect.DeepEqual(byAccount, want) {
+		t.Errorf("by account %v, want %v", byAccount, want)
+	}
+	byEnvironment := countProfiles(profiles, func(p AWSProfile) string { return profileEnvironment(p.Name) })
+	if want := []profileCount{{envOther, 3}, {envProd, 2}, {envTest, 1}}; !reflect.DeepEqual(byEnvironment, want) {
+		t.Errorf("by environment %v, want %v", byEnvironment, want)
+	}
+
+	want := "6 profiles\n" +
+		"\n" +
+		"account       profiles\n" +
+		"111111111111  3\n" +
+		"222222222222  2\n" +
+		"-             1\n" +
+		"\n" +
+		"environment  profiles\n" +
+		"other        3\n" +
+		"prod         2\n" +
+		"test         1\n"
+	if got := profileSummary(profiles); got != want {
+		t.Errorf("profileSummary:\n%s\nwant:\n%s", got, want)
+	}
+}
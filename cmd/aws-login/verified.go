@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const verifiedFile = ".aws-profile-selector-verified.json"
+
+// verification is the last successful verification of a profile.
+type verification struct {
+	Time     time.Time       `json:"time"`
+	Identity json.RawMessage `json:"identity"`
+}
+
+func verifiedFilePath() string {
+	return filepath.Join(homeDir(), verifiedFile)
+}
+
+// loadVerifications returns the last successful verification of each
+// profile, keyed by profile name, or none if the file can't be read.
+func loadVerifications() map[string]verification {
+	verifications := make(map[string]verification)
+	content, err := os.ReadFile(verifiedFilePath())
+	if err != nil {
+		return verifications
+	}
+	json.Unmarshal(content, &verifications)
+	return verifications
+}
+
+// recordVerification remembers that profileName was verified at now as the
+// identity in output.
+func recordVerification(profileName string, output []byte, now time.Time) error {
+	if !json.Valid(output) {
+		return nil
+	}
+	return withStateLock(func() error {
+		verifications := loadVerifications()
+		verifications[profileName] = verification{Time: now, Identity: output}
+		return saveVerifications(verifications)
+	})
+}
+
+func saveVerifications(verifications map[string]verification) error {
+	content, err := json.Marshal(verifications)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(verifiedFilePath(), content, 0644)
+}
+
+// profileAccount returns the account profileName was last verified in, or
+// else the aws_account_id it is configured with, or "" if neither is known.
+func profileAccount(profiles map[string]AWSProfile, verifications map[string]verification, profileName string) string {
+	if v, ok := verifications[profileName]; ok {
+		if identity, err := parseCallerIdentity(v.Identity); err == nil && identity.Account != "" {
+			return identity.Account
+		}
+	}
+	return profiles[profileName].AWSAccountID
+}
+
+// accountSwitch reports whether going from account from to account to
+// changes accounts. An unknown account on either side doesn't count.
+func accountSwitch(from, to string) bool {
+	return from != "" && to != "" && from != to
+}
+
+// freshVerification returns the identity profileName was last verified as
+// if that was less than within before now.
+func freshVerification(verifications map[string]verification, profileName string, within time.Duration, now time.Time) ([]byte, bool) {
+	v, ok := verifications[profileName]
+	if !ok || within <= 0 || now.Sub(v.Time) >= within {
+		return nil, false
+	}
+	return v.Identity, true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFreshVerification(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	identity := json.RawMessage(`{"Account": "123456789012"}`)
+	verifications := map[string]verification{"dev": {Time: now.Add(-4 * time.Minute), Identity: identity}}
+	tests := []struct {
+		profile string
+		within  time.Duration
+		fresh   bool
+	}{
+		{"dev", 5 * time.Minute, true},
+		{"dev", 4 * time.Minute, false},
+		{"dev", time.Minute, false},
+		{"dev", 0, false},
+		{"prod", time.Hour, false},
+	}
+	for _, tt := range tests {
+		output, fresh := freshVerification(verifications, tt.profile, tt.within, now)
+		if fresh != tt.fresh {
+			t.Errorf("%s within %v: fresh %v, want %v", tt.profile, tt.within, fresh, tt.fresh)
+		}
+		if fresh && string(output) != string(identity) {
+			t.Errorf("%s within %v: identity %s", tt.profile, tt.within, output)
+		}
+	}
+}
+
+func TestFreshWithinSkipsVerification(t *testing.T) {
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_access_key_id = AKIA1\naws_secret_access_key = s1\nregion = eu-west-1\n")
+	writeFile(t, filepath.Join(home, lastUsedFile), "dev")
+	log := filepath.Join(t.TempDir(), "commands")
+	env := []string{"AWS_CLI_PATH=" + fakeCLI(t, "echo \"$*\" >> "+log+"\n"+callerIdentityScript)}
+
+	for _, tt := range []struct {
+		age      time.Duration
+		verified bool
+	}{
+		{time.Minute, false},
+		{2 * time.Hour, true},
+	} {
+		verifications := map[string]verification{"dev": {
+			Time:     time.Now().Add(-tt.age),
+			Identity: json.RawMessage(`{"Account": "123456789012", "Arn": "arn:aws:iam::123456789012:user/dev"}`),
+		}}
+		content, err := json.Marshal(verifications)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(home, verifiedFile), string(content))
+		writeFile(t, log, "")
+
+		result := runMain(t, home, "", env, "-l", "-fresh-within", "1h")
+		if result.code != 0 {
+			t.Fatalf("verified %v ago: exit code %d, output %q", tt.age, result.code, result.stdout+result.stderr)
+		}
+		if verified := strings.Contains(readFile(t, log), "sts get-caller-identity"); verified != tt.verified {
+			t.Errorf("verified %v ago: verified again %v, want %v", tt.age, verified, tt.verified)
+		}
+	}
+}
+
+func TestAccountSwitch(t *testing.T) {
+	profiles := map[string]AWSProfile{
+		"dev":      {Name: "dev", AWSAccountID: "111111111111"},
+		"dev-ro":   {Name: "dev-ro", AWSAccountID: "111111111111"},
+		"verified": {Name: "verified", AWSAccountID: "999999999999"},
+		"unknown":  {Name: "unknown"},
+	}
+	verifications := map[string]verification{"verified": {Identity: json.RawMessage(`{"Account": "222222222222"}`)}}
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{"dev", "dev-ro", false},
+		{"dev", "verified", true},
+		{"dev", "unknown", false},
+		{"", "dev", false},
+	}
+	for _, tt := range tests {
+		from := profileAccount(profiles, verifications, tt.from)
+		to := profileAccount(profiles, verifications, tt.to)
+		if got := accountSwitch(from, to); got != tt.want {
+			t.Errorf("%q (%s) to %q (%s): switch %v, want %v", tt.from, from, tt.to, to, got, tt.want)
+		}
+	}
+	// The verified account wins over the configured one.
+	if got := profileAccount(profiles, verifications, "verified"); got != "222222222222" {
+		t.Errorf("profileAccount(verified) = %q", got)
+	}
+
+	home := t.TempDir()
+	writeFile(t, filepath.Join(home, ".aws", "credentials"), "[dev]\naws_account_id = 111111111111\n\n[dev-ro]\naws_account_id = 111111111111\n\n[prod]\naws_account_id = 222222222222\n")
+	env := []string{"AWS_PROFILE=dev", "AWS_PROFILE_SELECTOR_CONFIRM_ACCOUNT_SWITCH=true"}
+	// With -no-prompt, a confirmation prompt fails the run.
+	args := []string{"-no-prompt", "-no-verify", "-no-last-save", "-profile"}
+	if result := runMain(t, home, "", env, append(args, "dev-ro")...); result.code != 0 {
+		t.Errorf("same account: exit code %d, output %q", result.code, result.stdout+result.stderr)
+	}
+	result := runMain(t, home, "", env, append(args, "prod")...)
+	if result.code != exitError || !strings.Contains(result.stdout+result.stderr, errNoPrompt.Error()) {
+		t.Errorf("other account: exit code %d, output %q, want a confirmation prompt", result.code, result.stdout+result.stderr)
+	}
+}
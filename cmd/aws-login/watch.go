@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// watchIdentity verifies profileName every interval until interrupted,
+// printing a status line each time. The terminal bell rings when a check
+// fails after the previous one succeeded, which usually means the session
+// expired.
+func watchIdentity(profileName string, interval time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		output, err := getCallerIdentity(profileName)
+		ok := err == nil
+		if !ok && healthy {
+			fmt.Print("\a")
+		}
+		healthy = ok
+		fmt.Println(watchStatus(time.Now(), profileName, output, err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchStatus formats the result of one check made by watchIdentity.
+func watchStatus(now time.Time, profileName string, output []byte, err error) string {
+	timestamp := now.Format(time.RFC3339)
+	if err != nil {
+		reason := strings.TrimSpace(string(output))
+		if reason == "" {
+			reason = err.Error()
+		}
+		// The AWS CLI's errors can span lines; the first says what failed.
+		reason, _, _ = strings.Cut(reason, "\n")
+		return fmt.Sprintf("%s %s FAILED: %s", timestamp, profileName, reason)
+	}
+	identity, parseErr := parseCallerIdentity(output)
+	if parseErr != nil {
+		return fmt.Sprintf("%s %s FAILED: %v", timestamp, profileName, parseErr)
+	}
+	return fmt.Sprintf("%s %s ok %s", timestamp, profileName, identity.Arn)
+}
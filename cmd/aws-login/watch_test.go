@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWatchStatus(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	c := defaultConfig()
+	c.AWSCLIPath = fakeCLI(t, callerIdentityScript)
+	setConfig(t, c)
+	output, err := getCallerIdentity("dev")
+	if got, want := watchStatus(now, "dev", output, err), "2024-03-01T12:30:00Z dev ok arn:aws:iam::123456789012:user/dev"; got != want {
+		t.Errorf("success: %q, want %q", got, want)
+	}
+
+	cfg.AWSCLIPath = fakeCLI(t, "echo 'An error occurred (ExpiredToken): The security token included in the request is expired' >&2\necho 'more detail' >&2\nexit 254\n")
+	output, err = getCallerIdentity("dev")
+	if got, want := watchStatus(now, "dev", output, err), "2024-03-01T12:30:00Z dev FAILED: An error occurred (ExpiredToken): The security token included in the request is expired"; got != want {
+		t.Errorf("failure: %q, want %q", got, want)
+	}
+
+	if got, want := watchStatus(now, "dev", nil, errors.New("exit status 255")), "2024-03-01T12:30:00Z dev FAILED: exit status 255"; got != want {
+		t.Errorf("failure without output: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/huh"
+)
+
+// showNewProfileWizard asks for the settings of a new profile. existing
+// holds the profiles already defined, whose names can't be reused.
+func showNewProfileWizard(existing map[string]AWSProfile) (AWSProfile, error) {
+	var profile AWSProfile
+
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Profile name").
+				Validate(newProfileNameValidator(existing)).
+				Value(&profile.Name),
+			huh.NewInput().
+				Title("Access key id").
+				Description("Leave empty for a profile that assumes a role").
+				Value(&profile.AWSAccessKeyID),
+			huh.NewInput().
+				Title("Secret access key").
+				EchoMode(huh.EchoModePassword).
+				Value(&profile.AWSSecretAccessKey),
+			huh.NewInput().
+				Title("Region").
+				Description("Optional").
+				Validate(validateOptionalRegion).
+				Value(&profile.Region),
+			huh.NewInput().
+				Title("Role ARN").
+				Description("Optional").
+				Value(&profile.RoleARN),
+			huh.NewInput().
+				Title("Source profile").
+				Description("Required with a role ARN").
+				Value(&profile.SourceProfile),
+		),
+	).WithOutput(infoOutput)
+
+	if err := runForm(form); err != nil {
+		return AWSProfile{}, err
+	}
+	return normalizeNewProfile(profile)
+}
+
+// newProfileNameValidator checks the name of a new profile, which can't be
+// one of existing.
+func newProfileNameValidator(existing map[string]AWSProfile) func(name string) error {
+	return func(name string) error {
+		if !isValidProfileName(name) {
+			return errors.New("use letters, digits, '-' and '_', starting with a letter or digit")
+		}
+		if _, ok := existing[name]; ok {
+			return fmt.Errorf("profile %s already exists", name)
+		}
+		return nil
+	}
+}
+
+// validateOptionalRegion accepts an empty region or a recognized one.
+func validateOptionalRegion(region string) error {
+	if region == "" {
+		return nil
+	}
+	if _, ok := validateRegion(region); !ok {
+		return fmt.Errorf("unrecognized region %q", region)
+	}
+	return nil
+}
+
+// normalizeNewProfile trims the values entered in the wizard and checks
+// that the profile has credentials.
+func normalizeNewProfile(profile AWSProfile) (AWSProfile, error) {
+	profile.AWSAccessKeyID = strings.TrimSpace(profile.AWSAccessKeyID)
+	profile.AWSSecretAccessKey = strings.TrimSpace(profile.AWSSecretAccessKey)
+	profile.RoleARN = strings.TrimSpace(profile.RoleARN)
+	profile.SourceProfile = strings.TrimSpace(profile.SourceProfile)
+	if profile.Region != "" {
+		profile.Region, _ = validateRegion(profile.Region)
+	}
+	if !isCompleteProfile(profile) {
+		return AWSProfile{}, errors.New("a profile needs an access key id and secret, or a role ARN and source profile")
+	}
+	return profile, nil
+}
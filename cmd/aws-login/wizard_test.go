@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewProfileValidation(t *testing.T) {
+	validateName := newProfileNameValidator(map[string]AWSProfile{"dev": {Name: "dev"}})
+	for name, ok := range map[string]bool{"staging": true, "team_ops-2": true, "dev": false, "": false, "-dev": false, "has space": false} {
+		if err := validateName(name); (err == nil) != ok {
+			t.Errorf("name %q: error %v, want valid %v", name, err, ok)
+		}
+	}
+	for region, ok := range map[string]bool{"": true, "eu-west-1": true, "virginia": false} {
+		if err := validateOptionalRegion(region); (err == nil) != ok {
+			t.Errorf("region %q: error %v, want valid %v", region, err, ok)
+		}
+	}
+
+	profile, err := normalizeNewProfile(AWSProfile{Name: "staging", AWSAccessKeyID: " AKIA1 ", AWSSecretAccessKey: "wJalrXUtnFEMI ", Region: "EU-west1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (AWSProfile{Name: "staging", AWSAccessKeyID: "AKIA1", AWSSecretAccessKey: "wJalrXUtnFEMI", Region: "eu-west-1"}); profile != want {
+		t.Errorf("normalized profile %+v, want %+v", profile, want)
+	}
+	if _, err := normalizeNewProfile(AWSProfile{Name: "staging", AWSAccessKeyID: "AKIA1"}); err == nil {
+		t.Error("a profile with an access key but no secret was accepted")
+	}
+	if _, err := normalizeNewProfile(AWSProfile{Name: "staging", RoleARN: "arn:aws:iam::1:role/r", SourceProfile: "dev"}); err != nil {
+		t.Errorf("a role profile was rejected: %v", err)
+	}
+}
+
+func TestAppendProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".aws", "credentials")
+	profile := AWSProfile{Name: "staging", AWSAccessKeyID: "AKIA1", AWSSecretAccessKey: "wJalrXUtnFEMI", Region: "eu-west-1"}
+	if err := appendProfile(path, profile); err != nil {
+		t.Fatal(err)
+	}
+	want := "[staging]\naws_access_key_id = AKIA1\naws_secret_access_key = wJalrXUtnFEMI\nregion = eu-west-1\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("new file:\n%s\nwant:\n%s", got, want)
+	}
+	// The file holds the secret, so only its owner may read it.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("new file permissions %v, want 0600", perm)
+	}
+
+	role := AWSProfile{Name: "admin", RoleARN: "arn:aws:iam::1:role/admin", SourceProfile: "staging"}
+	if err := appendProfile(path, role); err != nil {
+		t.Fatal(err)
+	}
+	want += "\n[admin]\nrole_arn = arn:aws:iam::1:role/admin\nsource_profile = staging\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("after a second profile:\n%s\nwant:\n%s", got, want)
+	}
+	if err := appendProfile(path, role); err == nil {
+		t.Error("adding a profile that already exists succeeded")
+	}
+}